@@ -0,0 +1,315 @@
+// Command leaderboards-admin maintains the data the API derives from the
+// event log:
+//
+//	leaderboards-admin rebuild-leaderboards
+//	leaderboards-admin rebuild-stats
+//	leaderboards-admin backfill -from 2026-01-01 -to 2026-02-01
+//	leaderboards-admin verify
+//	leaderboards-admin indexes -create
+//
+// It reads events from MONGO_URI. Materialized leaderboards and cached
+// responses live in REDIS; without it those steps are skipped.
+package main
+
+import (
+	"LeaderboardsBackend/materialize"
+	"LeaderboardsBackend/store"
+	"LeaderboardsBackend/util"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/go-redis/redis"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"log"
+	"os"
+	"time"
+)
+
+// backfillBatch is how many events backfill applies at a time.
+const backfillBatch = 1000
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
+
+var commands = map[string]command{
+	"rebuild-leaderboards": {"recompute every materialized leaderboard", rebuildLeaderboards},
+	"rebuild-stats":        {"recompute every player rollup", rebuildStats},
+	"backfill":             {"apply events in a date range that bypassed ingestion", backfill},
+	"verify":               {"compare derived data with the event log, exiting 1 on differences", verify},
+	"indexes":              {"report missing and unused indexes, creating missing ones with -create", indexes},
+}
+
+// errDiscrepancies makes verify exit with status 1 after its report.
+var errDiscrepancies = errors.New("derived data differs from the event log")
+
+func main() {
+	log.SetFlags(0)
+
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+	}
+
+	if err := cmd.run(context.Background(), os.Args[2:]); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: leaderboards-admin <command> [flags]")
+	for _, name := range []string{"rebuild-leaderboards", "rebuild-stats", "backfill", "verify", "indexes"} {
+		fmt.Fprintf(os.Stderr, "  %-22s%s\n", name, commands[name].usage)
+	}
+	os.Exit(2)
+}
+
+func rebuildLeaderboards(ctx context.Context, args []string) error {
+	flag.NewFlagSet("rebuild-leaderboards", flag.ExitOnError).Parse(args)
+
+	boards, err := materialized(connect())
+	if err != nil {
+		return err
+	}
+
+	if err := boards.Rebuild(ctx); err != nil {
+		return err
+	}
+
+	log.Println("Rebuilt materialized leaderboards.")
+	return nil
+}
+
+func rebuildStats(ctx context.Context, args []string) error {
+	flag.NewFlagSet("rebuild-stats", flag.ExitOnError).Parse(args)
+
+	if err := connect().RebuildPlayerStats(ctx); err != nil {
+		return err
+	}
+
+	log.Println("Rebuilt player stats.")
+	return nil
+}
+
+// backfill applies events that were written straight to the database, e.g.
+// by an import, to everything derived from them. Rollups and materialized
+// scores are recomputed for the players involved rather than added to, so
+// backfilling events that were already applied is harmless. It stops at the
+// first failure, exiting 1, and can simply be run again.
+func backfill(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+	from := flags.String("from", "", "first day or RFC3339 time to backfill (required)")
+	to := flags.String("to", "", "day or RFC3339 time to stop before (required)")
+	dryRun := flags.Bool("dry-run", false, "count the events without applying them")
+	flags.Parse(args)
+
+	start, err := parseTime(*from)
+	if err != nil {
+		return fmt.Errorf("-from: %v", err)
+	}
+
+	end, err := parseTime(*to)
+	if err != nil {
+		return fmt.Errorf("-to: %v", err)
+	}
+
+	if !start.Before(end) {
+		return fmt.Errorf("-from must be before -to")
+	}
+
+	events := connect()
+
+	var hooks []func(events []util.MongoResult) error
+	if !*dryRun {
+		hooks = append(hooks, func(batch []util.MongoResult) error {
+			return events.UpdatePlayerStats(ctx, batch)
+		}, func(batch []util.MongoResult) error {
+			return events.UpdateGameSummaries(ctx, batch)
+		})
+
+		if os.Getenv("REDIS") != "" {
+			boards, err := materialized(events)
+			if err != nil {
+				return err
+			}
+
+			util.SetupCache()
+			hooks = append(hooks, func(batch []util.MongoResult) error {
+				return boards.Update(ctx, batch)
+			}, util.InvalidateEvents)
+		}
+	}
+
+	count := 0
+	err = events.EachEvent(ctx, millis(start), millis(end), backfillBatch, func(batch []util.MongoResult) error {
+		for _, hook := range hooks {
+			if err := hook(batch); err != nil {
+				return fmt.Errorf("backfilled %d events before failing: %v", count, err)
+			}
+		}
+
+		count += len(batch)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		log.Printf("Found %d events to backfill.", count)
+	} else {
+		log.Printf("Backfilled %d events.", count)
+	}
+
+	return nil
+}
+
+func verify(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	checkStats := flags.Bool("stats", true, "verify player rollups")
+	checkBoards := flags.Bool("leaderboards", true, "verify materialized leaderboards, if REDIS is set")
+	flags.Parse(args)
+
+	events := connect()
+	differences := 0
+
+	if *checkStats {
+		stored, err := events.AllPlayerStats(ctx)
+		if err != nil {
+			return err
+		}
+
+		recomputed, err := events.RecomputePlayerStats(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, d := range store.CompareStats(stored, recomputed) {
+			fmt.Printf("stats %s %s/%s %s: stored %d, expected %d\n", d.Player, d.Game, d.Mode, d.Total, d.Stored, d.Expected)
+			differences++
+		}
+	}
+
+	if *checkBoards && os.Getenv("REDIS") != "" {
+		boards, err := materialized(events)
+		if err != nil {
+			return err
+		}
+
+		found, err := boards.Verify(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, d := range found {
+			fmt.Printf("leaderboard %s/%s %s %s: stored %g, expected %g\n", d.Game, d.Mode, d.Field, d.Player, d.Stored, d.Expected)
+			differences++
+		}
+	}
+
+	if differences > 0 {
+		log.Printf("Found %d discrepancies.", differences)
+		return errDiscrepancies
+	}
+
+	log.Println("No discrepancies found.")
+	return nil
+}
+
+// indexes reports the declared indexes that are missing, the ones that
+// haven't been used since the server started counting, and any that aren't
+// declared at all. Usage is tracked per server and resets when it restarts.
+func indexes(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("indexes", flag.ExitOnError)
+	create := flags.Bool("create", false, "create missing indexes before reporting")
+	flags.Parse(args)
+
+	events := connect()
+
+	if *create {
+		if err := events.EnsureIndexes(ctx); err != nil {
+			return err
+		}
+	}
+
+	report, err := events.IndexReport(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, index := range report {
+		switch {
+		case !index.Exists:
+			fmt.Printf("missing    %s.%s (%s)\n", index.Collection, index.Name, index.Purpose)
+		case !index.Declared:
+			fmt.Printf("undeclared %s.%s, used %d times since %s\n", index.Collection, index.Name, index.Ops, index.Since.Format(time.RFC3339))
+		case index.Unused():
+			fmt.Printf("unused     %s.%s since %s (%s)\n", index.Collection, index.Name, index.Since.Format(time.RFC3339), index.Purpose)
+		default:
+			fmt.Printf("ok         %s.%s, used %d times\n", index.Collection, index.Name, index.Ops)
+		}
+	}
+
+	return nil
+}
+
+func connect() *store.MongoStore {
+	client, err := mongo.NewClient(options.Client().ApplyURI(os.Getenv("MONGO_URI")))
+	if err != nil {
+		log.Fatal("Failed to connect to mongo: ", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := client.Connect(ctx); err != nil {
+		log.Fatal("Failed to connect to mongo: ", err)
+	}
+
+	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		log.Fatal("Failed to connect to mongo: ", err)
+	}
+
+	return store.NewMongoStore(client)
+}
+
+// materialized connects to the materialized leaderboards in REDIS.
+func materialized(events store.Backend) (*materialize.Store, error) {
+	addr := os.Getenv("REDIS")
+	if addr == "" {
+		return nil, fmt.Errorf("REDIS is not set")
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	if err := client.Ping().Err(); err != nil {
+		return nil, fmt.Errorf("failed to connect to redis: %v", err)
+	}
+
+	return materialize.Wrap(events, client), nil
+}
+
+// parseTime reads a day (at midnight UTC) or an RFC3339 time.
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, fmt.Errorf("missing")
+	}
+
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,349 @@
+package fields
+
+import (
+	"LeaderboardsBackend/metric"
+	"LeaderboardsBackend/pipeline"
+	"LeaderboardsBackend/store"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Sort directions. Leaderboards sort a field in its declared direction unless
+// the filter says otherwise with a "-" (ascending) or "+" (descending) prefix.
+const (
+	SortAscending  = "asc"
+	SortDescending = "desc"
+)
+
+// Formats tell clients how to display a value. FormatDuration is shown as
+// mm:ss, with the field's unit saying what the value counts; FormatPercent
+// values are fractions of 1.
+const (
+	FormatNumber   = "number"
+	FormatDuration = "duration"
+	FormatPercent  = "percent"
+)
+
+// Display describes how a score is presented and ranked by default.
+type Display struct {
+	Name   string `json:"name,omitempty"`
+	Unit   string `json:"unit,omitempty"`
+	Format string `json:"format,omitempty"`
+	Sort   string `json:"sort,omitempty"`
+}
+
+func (d Display) validate() error {
+	switch d.Format {
+	case "", FormatNumber, FormatDuration, FormatPercent:
+	default:
+		return fmt.Errorf("unknown format %q", d.Format)
+	}
+
+	switch d.Sort {
+	case "", SortAscending, SortDescending:
+	default:
+		return fmt.Errorf("unknown sort %q", d.Sort)
+	}
+
+	return nil
+}
+
+// withDefaults fills in what a declaration left out.
+func (d Display) withDefaults(name string) Display {
+	if d.Name == "" {
+		d.Name = name
+	}
+	if d.Format == "" {
+		d.Format = FormatNumber
+	}
+	if d.Sort == "" {
+		d.Sort = SortDescending
+	}
+
+	return d
+}
+
+// Field declares how a score field is displayed and treated by default.
+type Field struct {
+	Field string `json:"field"`
+	Display
+	Aggregation string `json:"aggregation,omitempty"`
+}
+
+// Metric declares a derived score computed from score fields and the kills,
+// deaths, wins and games of each player. MinGames keeps players who played
+// fewer games off the metric's board unless a request overrides it.
+type Metric struct {
+	Metric string `json:"metric"`
+	Display
+	Expression string `json:"expression"`
+	MinGames   int    `json:"min_games,omitempty"`
+
+	parsed *metric.Expression
+}
+
+// Scope declares the fields and metrics of a game, or of one of its modes. An
+// empty game applies to every game; a mode scope overrides its game's scope,
+// which in turn overrides the global one.
+type Scope struct {
+	Game    string   `json:"game"`
+	Mode    string   `json:"mode"`
+	Fields  []Field  `json:"fields"`
+	Metrics []Metric `json:"metrics"`
+}
+
+// builtinMinGames keeps players with a handful of lucky games off the
+// built-in ratio boards.
+const builtinMinGames = 10
+
+// builtin is available to every game and can be overridden by the registry.
+var builtin = Scope{Metrics: []Metric{
+	builtinMetric("kd", "kills/deaths", Display{Name: "K/D"}),
+	builtinMetric("winrate", "wins/games", Display{Name: "Win rate", Format: FormatPercent}),
+}}
+
+var (
+	mu     sync.RWMutex
+	scopes = []Scope{builtin}
+)
+
+func builtinMetric(name, expression string, display Display) Metric {
+	parsed, err := metric.Parse(expression)
+	if err != nil {
+		panic(err)
+	}
+
+	return Metric{Metric: name, Display: display, Expression: expression, MinGames: builtinMinGames, parsed: parsed}
+}
+
+// Load reads the field registry, a JSON array of scopes, replacing any
+// registry loaded before.
+func Load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var loaded []Scope
+	if err := json.NewDecoder(file).Decode(&loaded); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	for _, scope := range loaded {
+		if scope.Game == "" && scope.Mode != "" {
+			return fmt.Errorf("%s: mode %q has no game", path, scope.Mode)
+		}
+
+		for i, declared := range scope.Metrics {
+			if !pipeline.ValidField(declared.Metric) {
+				return fmt.Errorf("%s: invalid metric %q", path, declared.Metric)
+			}
+
+			if scope.Metrics[i].parsed, err = metric.Parse(declared.Expression); err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+
+			if err := declared.validate(); err != nil {
+				return fmt.Errorf("%s: metric %q: %v", path, declared.Metric, err)
+			}
+		}
+
+		for _, field := range scope.Fields {
+			if !pipeline.ValidField(field.Field) {
+				return fmt.Errorf("%s: invalid field %q", path, field.Field)
+			}
+
+			if field.Aggregation != "" {
+				if err := store.ValidateAggregation(field.Aggregation); err != nil {
+					return fmt.Errorf("%s: field %q: %v", path, field.Field, err)
+				}
+			}
+
+			if err := field.validate(); err != nil {
+				return fmt.Errorf("%s: field %q: %v", path, field.Field, err)
+			}
+		}
+	}
+
+	mu.Lock()
+	scopes = append([]Scope{builtin}, loaded...)
+	mu.Unlock()
+
+	return nil
+}
+
+// matching returns the scopes that apply to a game mode, most general first.
+func matching(game, mode string) []Scope {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	levels := [][2]string{{"", ""}}
+	if game != "" {
+		levels = append(levels, [2]string{game, ""})
+
+		if mode != "" {
+			levels = append(levels, [2]string{game, mode})
+		}
+	}
+
+	var found []Scope
+	for _, level := range levels {
+		for _, scope := range scopes {
+			if scope.Game == level[0] && scope.Mode == level[1] {
+				found = append(found, scope)
+			}
+		}
+	}
+
+	return found
+}
+
+// Lookup returns the declared fields of a game mode, keyed by field name.
+func Lookup(game, mode string) map[string]Field {
+	found := make(map[string]Field)
+	for _, scope := range matching(game, mode) {
+		for _, field := range scope.Fields {
+			found[field.Field] = field
+		}
+	}
+
+	return found
+}
+
+// LookupMetrics returns the metrics of a game mode, keyed by metric name.
+func LookupMetrics(game, mode string) map[string]Metric {
+	found := make(map[string]Metric)
+	for _, scope := range matching(game, mode) {
+		for _, declared := range scope.Metrics {
+			found[declared.Metric] = declared
+		}
+	}
+
+	return found
+}
+
+// Describe lists the fields of a game mode, both declared and recorded, and
+// its metrics, each sorted by name and with every default filled in.
+func Describe(game, mode string, recorded []string) ([]Field, []Metric) {
+	declared := Lookup(game, mode)
+	for _, name := range recorded {
+		if _, ok := declared[name]; !ok {
+			declared[name] = Field{Field: name}
+		}
+	}
+
+	var fields []Field
+	for name, field := range declared {
+		field.Display = field.withDefaults(name)
+		if field.Aggregation == "" {
+			field.Aggregation = store.AggregateSum
+		}
+
+		fields = append(fields, field)
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Field < fields[j].Field
+	})
+
+	var metrics []Metric
+	for name, declared := range LookupMetrics(game, mode) {
+		declared.Display = declared.withDefaults(name)
+		metrics = append(metrics, declared)
+	}
+
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].Metric < metrics[j].Metric
+	})
+
+	return fields, metrics
+}
+
+// ParseFilters resolves a leaderboard filter list such as "min:time,kd" into
+// query: the plain "-time", "kd" filters the store sorts by, with each
+// field's declared direction unless the filter has a "-" or "+" prefix, the
+// aggregation of every field starting from the registry defaults, and the
+// metrics the filters name along with the games they require.
+func ParseFilters(raw string, query *store.LeaderboardQuery) error {
+	query.Filters = nil
+	query.Aggregations = make(map[string]string)
+	for name, field := range Lookup(query.Game, query.Mode) {
+		if field.Aggregation != "" {
+			query.Aggregations[name] = field.Aggregation
+		}
+	}
+
+	if raw == "" {
+		return nil
+	}
+
+	declared := Lookup(query.Game, query.Mode)
+	metrics := LookupMetrics(query.Game, query.Mode)
+	explicit := make(map[string]string)
+
+	for _, filter := range strings.Split(raw, ",") {
+		field, direction := filter, ""
+		if strings.HasPrefix(field, "-") {
+			field, direction = field[1:], SortAscending
+		} else if strings.HasPrefix(field, "+") {
+			field, direction = field[1:], SortDescending
+		}
+
+		if i := strings.Index(field, ":"); i >= 0 {
+			aggregation := field[:i]
+			field = field[i+1:]
+
+			if err := store.ValidateAggregation(aggregation); err != nil {
+				return fmt.Errorf("invalid filter %s: %v", filter, err)
+			}
+
+			if _, ok := metrics[field]; ok {
+				return fmt.Errorf("invalid filter %s: metric %s can't be aggregated", filter, field)
+			}
+
+			if previous, ok := explicit[field]; ok && previous != aggregation {
+				return fmt.Errorf("invalid filter %s: %s is already aggregated with %s", filter, field, previous)
+			}
+
+			explicit[field] = aggregation
+			query.Aggregations[field] = aggregation
+		}
+
+		if !pipeline.ValidField(field) {
+			return fmt.Errorf("invalid filter %s", filter)
+		}
+
+		if direction == "" {
+			direction = declared[field].Sort
+		}
+
+		if derived, ok := metrics[field]; ok {
+			if query.Metrics == nil {
+				query.Metrics = make(map[string]*metric.Expression)
+			}
+
+			query.Metrics[field] = derived.parsed
+			query.Outcomes = true
+			if derived.MinGames > query.MinGames {
+				query.MinGames = derived.MinGames
+			}
+
+			if direction == "" {
+				direction = derived.Sort
+			}
+		}
+
+		if direction == SortAscending {
+			field = "-" + field
+		}
+
+		query.Filters = append(query.Filters, field)
+	}
+
+	return nil
+}
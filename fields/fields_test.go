@@ -0,0 +1,132 @@
+package fields
+
+import (
+	"LeaderboardsBackend/store"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+const registry = `[
+	{"game": "", "fields": [{"field": "time", "sort": "asc", "aggregation": "min"}]},
+	{"game": "arena", "fields": [{"field": "streak", "aggregation": "max"}],
+	 "metrics": [{"metric": "kpg", "expression": "kills/games", "min_games": 20, "sort": "desc"}]}
+]`
+
+// load installs a registry for one test; callers defer restore.
+func load(t *testing.T, contents string) {
+	file, err := ioutil.TempFile("", "fields")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.WriteString(contents); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	if err := Load(file.Name()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// restore goes back to the built-in registry.
+func restore() {
+	mu.Lock()
+	scopes = []Scope{builtin}
+	mu.Unlock()
+}
+
+func TestParseFilters(t *testing.T) {
+	load(t, registry)
+	defer restore()
+
+	tests := []struct {
+		raw          string
+		filters      []string
+		aggregations map[string]string
+		metrics      []string
+		minGames     int
+	}{
+		{"", nil, map[string]string{"time": "min", "streak": "max"}, nil, 0},
+		{"kills", []string{"kills"}, map[string]string{"time": "min", "streak": "max"}, nil, 0},
+		{"-kills", []string{"-kills"}, map[string]string{"time": "min", "streak": "max"}, nil, 0},
+		{"time", []string{"-time"}, map[string]string{"time": "min", "streak": "max"}, nil, 0},
+		{"+time,-kills", []string{"time", "-kills"}, map[string]string{"time": "min", "streak": "max"}, nil, 0},
+		{"max:time", []string{"-time"}, map[string]string{"time": "max", "streak": "max"}, nil, 0},
+		{"sum:streak,kills", []string{"streak", "kills"}, map[string]string{"time": "min", "streak": "sum"}, nil, 0},
+		{"kd", []string{"kd"}, map[string]string{"time": "min", "streak": "max"}, []string{"kd"}, builtinMinGames},
+		{"kpg,winrate", []string{"kpg", "winrate"}, map[string]string{"time": "min", "streak": "max"}, []string{"kpg", "winrate"}, 20},
+	}
+
+	for _, test := range tests {
+		query := store.LeaderboardQuery{Game: "arena", Mode: "solo"}
+		if err := ParseFilters(test.raw, &query); err != nil {
+			t.Errorf("%q: %v", test.raw, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(query.Filters, test.filters) {
+			t.Errorf("%q: got filters %v, want %v", test.raw, query.Filters, test.filters)
+		}
+
+		if !reflect.DeepEqual(query.Aggregations, test.aggregations) {
+			t.Errorf("%q: got aggregations %v, want %v", test.raw, query.Aggregations, test.aggregations)
+		}
+
+		var metrics []string
+		for _, name := range test.metrics {
+			if query.Metrics[name] == nil {
+				t.Errorf("%q: missing metric %s", test.raw, name)
+			}
+			metrics = append(metrics, name)
+		}
+		if len(query.Metrics) != len(metrics) {
+			t.Errorf("%q: got %d metrics, want %v", test.raw, len(query.Metrics), metrics)
+		}
+
+		if query.Outcomes != (len(test.metrics) > 0) {
+			t.Errorf("%q: got outcomes %v", test.raw, query.Outcomes)
+		}
+
+		if query.MinGames != test.minGames {
+			t.Errorf("%q: got min games %d, want %d", test.raw, query.MinGames, test.minGames)
+		}
+	}
+}
+
+func TestParseFiltersScopes(t *testing.T) {
+	load(t, registry)
+	defer restore()
+
+	// streak and kpg are only declared for arena.
+	query := store.LeaderboardQuery{Game: "other", Mode: "solo"}
+	if err := ParseFilters("streak", &query); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := map[string]string{"time": "min"}; !reflect.DeepEqual(query.Aggregations, want) {
+		t.Errorf("got aggregations %v, want %v", query.Aggregations, want)
+	}
+	if query.Metrics != nil {
+		t.Errorf("got metrics %v, want none", query.Metrics)
+	}
+}
+
+func TestParseFiltersErrors(t *testing.T) {
+	for _, raw := range []string{
+		"median:kills",
+		"min:kd",
+		"min:kills,max:kills",
+		"$where",
+		"kills,",
+		"scores.kills",
+	} {
+		query := store.LeaderboardQuery{Game: "arena"}
+		if err := ParseFilters(raw, &query); err == nil {
+			t.Errorf("%q: expected an error, got filters %v", raw, query.Filters)
+		}
+	}
+}
@@ -1,15 +1,14 @@
 package game
 
 import (
+	"LeaderboardsBackend/fields"
+	"LeaderboardsBackend/store"
 	"LeaderboardsBackend/util"
 	json2 "encoding/json"
-	"fmt"
 	"github.com/gin-gonic/gin"
-	"github.com/simagix/keyhole/mdb"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"log"
 	"strconv"
+	"time"
 )
 
 type gameRequest struct {
@@ -57,43 +56,75 @@ type timeline struct {
 	Time           int64
 }
 
-type gamesResult struct {
-	Game      interface{} `bson:"_id"`
-	GameModes [] string   `bson:"gamemodes"`
+var Store store.Backend
+
+// Summaries is set when the event stream worker keeps the game summaries in
+// step with the Events collection; game lists are grouped from Finish events
+// otherwise.
+var Summaries bool
+
+// New games and modes are rare, and a finished instance never changes.
+var (
+	gamesPolicy    = util.CachePolicy{TTL: time.Hour}
+	listPolicy     = util.CachePolicy{TTL: time.Minute, Stale: 5 * time.Minute, Refresh: true}
+	instancePolicy = util.CachePolicy{TTL: time.Hour}
+)
+
+func Register(r *gin.Engine, events store.Backend, summaries bool) {
+	Store = events
+	Summaries = summaries
+
+	util.CachedGETWithPolicy(r, "/games", gamesPolicy, gamesHandler)
+	util.CachedGETWithPolicy(r, "/game/list", listPolicy, gameListHandler)
+	util.CachedGETWithPolicy(r, "/game/list/:game", listPolicy, gameListHandler)
+	util.CachedGETWithPolicy(r, "/game/list/:game/:mode", listPolicy, gameListHandler)
+	util.CachedGETWithPolicy(r, "/game/instance/:id", instancePolicy, instanceHandler)
+	util.CachedGETWithPolicy(r, "/v1/games/:game/:mode/fields", gamesPolicy, fieldsHandler)
 }
 
-type gameListResult struct {
-	GameID     string            `bson:"game_id"`
-	GameModeID string            `bson:"game_mode_id"`
-	InstanceID string            `bson:"instance_id"`
-	Winners    map[string]string `bson:"winners"`
-	Losers     map[string]string `bson:"losers"`
-	EndTime    int64             `bson:"end_time"`
+type fieldsResponse struct {
+	Fields  []fields.Field  `json:"fields"`
+	Metrics []fields.Metric `json:"metrics"`
 }
 
-var Client *mongo.Client
+// fieldsHandler describes the score fields and metrics of a game mode, so
+// clients know how to label, format and sort each one.
+func fieldsHandler(c *gin.Context) []byte {
+	var request gameRequest
+	if err := c.ShouldBindUri(&request); err != nil {
+		c.JSON(400, gin.H{"error": err})
+		return nil
+	}
+
+	util.Tag(c, util.ModeTag(request.Game, request.Mode))
 
-func Register(r *gin.Engine, client *mongo.Client) {
-	Client = client
+	recorded, err := Store.ScoreFields(c, request.Game, request.Mode)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return nil
+	}
 
-	util.CachedGET(r, "/games", gamesHandler)
-	util.CachedGET(r, "/game/list", gameListHandler)
-	util.CachedGET(r, "/game/list/:game", gameListHandler)
-	util.CachedGET(r, "/game/list/:game/:mode", gameListHandler)
-	util.CachedGET(r, "/game/instance/:id", instanceHandler)
+	var response fieldsResponse
+	response.Fields, response.Metrics = fields.Describe(request.Game, request.Mode, recorded)
+
+	json, err := json2.MarshalIndent(response, "", "    ")
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return nil
+	}
+
+	return json
 }
 
 func gameListHandler(c *gin.Context) []byte {
-	var err error
-	var collection *mongo.Collection
-	var cur *mongo.Cursor
-
 	var request gameRequest
 	if err := c.ShouldBindUri(&request); err != nil {
 		c.JSON(400, gin.H{"error": err})
 		return nil
 	}
 
+	util.Tag(c, util.ModeTag(request.Game, request.Mode))
+
 	page, err := strconv.Atoi(c.Query("page"))
 	length, err := strconv.Atoi(c.Query("length"))
 	if err != nil {
@@ -101,71 +132,29 @@ func gameListHandler(c *gin.Context) []byte {
 		length = 100
 	}
 
-	pipeline := `[
-		{
-			"$match" : {
-				"analytic_event_type": "Finish"
-				%s
-			}
-		},
-        { 
-            "$group" : {
-                "_id" : {
-                    "game_id" : "$game_id", 
-                    "game_mode_id" : "$game_mode_id", 
-                    "instance_id" : "$instance_id", 
-                    "winners" : "$winners", 
-                    "losers" : "$losers", 
-                    "end_time" : "$time_code"
-                }
-            }
-        }, 
-        { 
-            "$replaceRoot" : {
-                "newRoot" : "$_id"
-            }
-        }, 
-        { 
-            "$sort" : {
-                "end_time" : -1.0
-            }
-        },
-		{
-			"$skip": %d
-		},
-		{
-			"$limit": %d
-		}
-    ]`
-
-	match := ``
-	if request.Game != "" {
-		match += ",\"game_id\" : \"" + request.Game + "\" "
-
-		if request.Mode != "" {
-			match += ",\"game_mode_id\": \"" + request.Mode + "\""
-		}
-	}
-
-	pipeline = fmt.Sprintf(pipeline, match, (page*length)-length, length)
-
-	collection = Client.Database("Analytics").Collection("Events")
-	opts := options.Aggregate()
-	opts.SetAllowDiskUse(true)
-	if cur, err = collection.Aggregate(c, mdb.MongoPipeline(pipeline), opts); err != nil {
-		log.Fatal(err)
+	query := store.GameListQuery{
+		Game:   request.Game,
+		Mode:   request.Mode,
+		Page:   page,
+		Length: length,
 	}
 
-	var results [] gameListResult
-	for cur.Next(c) {
-		var result gameListResult
-		err := cur.Decode(&result)
+	list := Store.GameList
+	if Summaries {
+		built, err := Store.SummariesBuilt(c)
 		if err != nil {
-			c.JSON(500, gin.H{"error": err})
-			return nil
+			log.Println("Failed to check game summaries... grouping events instead. ", err)
 		}
 
-		results = append(results, result)
+		if built {
+			list = Store.SummaryGameList
+		}
+	}
+
+	results, err := list(c, query)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return nil
 	}
 
 	json, err := json2.MarshalIndent(results, "", "    ")
@@ -178,41 +167,12 @@ func gameListHandler(c *gin.Context) []byte {
 }
 
 func gamesHandler(c *gin.Context) []byte {
-	var err error
-	var collection *mongo.Collection
-	var cur *mongo.Cursor
-
-	pipeline := `[
-		%s
-		{
-            "$group" : {
-                "_id" : "$game_id",
-                "gamemodes" : {
-                    "$addToSet" : "$game_mode_id"
-                }
-            }
-        }
-    ]`
-
-	collection = Client.Database("Analytics").Collection("Events")
-	opts := options.Aggregate()
-	opts.SetAllowDiskUse(true)
-	if cur, err = collection.Aggregate(c, mdb.MongoPipeline(pipeline), opts); err != nil {
-		c.JSON(500, gin.H{"error": err})
-		return nil
-	}
+	util.Tag(c, util.AllGamesTag)
 
-	var results [] gamesResult
-	for cur.Next(c) {
-		var result gamesResult
-		err := cur.Decode(&result)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		if result.Game != nil {
-			results = append(results, result)
-		}
+	results, err := Store.Games(c)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return nil
 	}
 
 	json, err := json2.MarshalIndent(results, "", "    ")
@@ -233,42 +193,17 @@ func instanceHandler(c *gin.Context) []byte {
 		return nil
 	}
 
-	pipeline := `
-	[{ 
-			"$match" : {
-				"instance_id" : "%s"
-			}
-		}, 
-		{ 
-			"$project" : {
-				"time_code" : 1.0, 
-				"server_event_type" : 1.0,
-				"world_name": 1.0,
-				"analytic_event_type" : 1.0, 
-				"from" : 1.0, 
-				"to" : 1.0, 
-				"value" : 1.0, 
-				"score_field" : 1.0, 
-				"player_name" : 1.0, 
-				"player_uuid" : 1.0, 
-				"death_event_type" : 1.0, 
-				"death_details" : 1.0, 
-				"killer_name" : 1.0, 
-				"killer_uuid" : 1.0, 
-				"winners" : 1.0, 
-				"losers" : 1.0, 
-				"finish_event_type" : 1.0,
-				"author" : 1.0,
-				"phrase" : 1.0,
-				"tags": 1.0,
-				"time_since_start": 1.0
-			}
-	}]`
-	pipeline = fmt.Sprintf(pipeline, request.ID)
+	util.Tag(c, util.InstanceTag(request.ID))
+
+	events, err := Store.InstanceEvents(c, request.ID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return nil
+	}
 
 	var fullGameResponse fullGameResponse
 
-	util.RunPipelineOnEvents(pipeline, Client, c, func(result util.MongoResult) {
+	for _, result := range events {
 		if result.ServerEventType == "Game" && result.AnalyticEventType == "Finish" {
 			fullGameResponse.EndTime = result.TimeCode
 			fullGameResponse.Losers = result.Losers
@@ -319,7 +254,7 @@ func instanceHandler(c *gin.Context) []byte {
 
 			fullGameResponse.Timeline = append(fullGameResponse.Timeline, entry)
 		}
-	})
+	}
 
 	json, err := json2.MarshalIndent(fullGameResponse, "", "    ")
 	if err != nil {
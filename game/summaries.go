@@ -0,0 +1,37 @@
+package game
+
+import (
+	"LeaderboardsBackend/util"
+	"context"
+	"log"
+)
+
+// UpdateSummaries is a hook storing the summaries of games finished in newly
+// written events.
+func UpdateSummaries(events []util.MongoResult) error {
+	return Store.UpdateGameSummaries(context.Background(), events)
+}
+
+// EnsureSummaries builds the game summaries unless they have been built
+// before, so game lists switch over to them once they are complete.
+func EnsureSummaries() {
+	built, err := Store.SummariesBuilt(context.Background())
+	if err != nil {
+		log.Println("Failed to check game summaries: ", err)
+		return
+	}
+
+	if !built {
+		RebuildSummaries()
+	}
+}
+
+// RebuildSummaries recomputes the game summaries from the event log.
+func RebuildSummaries() {
+	if err := Store.RebuildGameSummaries(context.Background()); err != nil {
+		log.Println("Failed to rebuild game summaries: ", err)
+		return
+	}
+
+	log.Println("Rebuilt game summaries.")
+}
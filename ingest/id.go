@@ -0,0 +1,21 @@
+package ingest
+
+import (
+	"LeaderboardsBackend/util"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// EventID derives a stable id for events sent without one, so a batch that a
+// game server retries is recognised instead of being counted twice. The id
+// hashes every field of the event, so only an exact repeat is a duplicate;
+// JSON encoding sorts map keys, which keeps the hash stable.
+func EventID(event util.MongoResult) string {
+	event.EventID = ""
+
+	data, _ := json.Marshal(event)
+	sum := sha1.Sum(data)
+
+	return hex.EncodeToString(sum[:])
+}
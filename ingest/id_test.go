@@ -0,0 +1,104 @@
+package ingest
+
+import (
+	"LeaderboardsBackend/store"
+	"LeaderboardsBackend/util"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func event() util.MongoResult {
+	return util.MongoResult{
+		TimeCode:          1559347200000,
+		ServerEventType:   "Game",
+		GameID:            "arena",
+		GameModeID:        "solo",
+		InstanceID:        "1",
+		AnalyticEventType: "Score",
+		ScoreField:        "kills",
+		Value:             3,
+		PlayerName:        "alice",
+		PlayerUUID:        "alice",
+		Tags:              map[string]string{"b": "2", "a": "1", "c": "3"},
+	}
+}
+
+func TestEventIDStable(t *testing.T) {
+	id := EventID(event())
+	if len(id) != 40 {
+		t.Errorf("got %q, want a hex sha1", id)
+	}
+
+	for i := 0; i < 20; i++ {
+		if again := EventID(event()); again != id {
+			t.Fatalf("got %s then %s for the same event", id, again)
+		}
+	}
+}
+
+func TestEventIDIgnoresExistingID(t *testing.T) {
+	withID := event()
+	withID.EventID = "sent-by-the-server"
+
+	if EventID(withID) != EventID(event()) {
+		t.Error("an event's own id changed its derived id")
+	}
+
+	if withID.EventID != "sent-by-the-server" {
+		t.Error("EventID modified the event")
+	}
+}
+
+func TestEventIDDiffers(t *testing.T) {
+	id := EventID(event())
+
+	changes := map[string]func(e *util.MongoResult){
+		"time code": func(e *util.MongoResult) { e.TimeCode++ },
+		"value":     func(e *util.MongoResult) { e.Value++ },
+		"player":    func(e *util.MongoResult) { e.PlayerUUID = "bob" },
+		"instance":  func(e *util.MongoResult) { e.InstanceID = "2" },
+		"tag":       func(e *util.MongoResult) { e.Tags = map[string]string{"a": "1"} },
+	}
+
+	for name, change := range changes {
+		changed := event()
+		change(&changed)
+
+		if EventID(changed) == id {
+			t.Errorf("changing the %s kept the same id", name)
+		}
+	}
+}
+
+func TestEventsHandlerSkipsDuplicates(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	Store = store.NewMemoryStore(nil)
+
+	r := gin.New()
+	r.POST("/v1/events", eventsHandler)
+
+	batch := `[
+		{"time_code": 1000, "server_event_type": "Game", "game_id": "arena", "game_mode_id": "solo", "instance_id": "1",
+		 "analytic_event_type": "Score", "score_field": "kills", "value": 1, "player_uuid": "alice", "player_name": "alice"},
+		{"event_id": "sent-by-the-server", "time_code": 1000, "server_event_type": "Game", "game_id": "arena", "game_mode_id": "solo",
+		 "instance_id": "1", "analytic_event_type": "Score", "score_field": "kills", "value": 1, "player_uuid": "bob", "player_name": "bob"}
+	]`
+
+	tests := []struct{ accepted, skipped int }{{2, 0}, {0, 2}}
+	for i, test := range tests {
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, httptest.NewRequest("POST", "/v1/events", strings.NewReader(batch)))
+
+		var response struct{ Accepted, Skipped int }
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatalf("attempt %d: %v in %s", i+1, err, recorder.Body.String())
+		}
+
+		if response.Accepted != test.accepted || response.Skipped != test.skipped {
+			t.Errorf("attempt %d: got %+v, want %+v", i+1, response, test)
+		}
+	}
+}
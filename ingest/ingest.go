@@ -0,0 +1,91 @@
+package ingest
+
+import (
+	"LeaderboardsBackend/store"
+	"LeaderboardsBackend/util"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+)
+
+// maxBatchSize caps how many events a single request may carry.
+const maxBatchSize = 1000
+
+// maxBodySize caps the request body so a bad client can't exhaust memory.
+const maxBodySize = 8 << 20
+
+type eventError struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+var Store store.EventWriter
+
+var hooks []func(events []util.MongoResult) error
+
+func Register(r *gin.Engine, events store.EventWriter) {
+	Store = events
+
+	r.POST("/v1/events", util.RequireToken("INGEST_TOKEN"), eventsHandler)
+}
+
+// OnInserted registers a hook that runs after every batch with the events
+// that were actually written, e.g. to invalidate cached responses. A failing
+// hook is logged; the events are stored either way.
+func OnInserted(hook func(events []util.MongoResult) error) {
+	hooks = append(hooks, hook)
+}
+
+func eventsHandler(c *gin.Context) {
+	var events []util.MongoResult
+
+	decoder := json.NewDecoder(http.MaxBytesReader(c.Writer, c.Request.Body, maxBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&events); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid event batch: " + err.Error()})
+		return
+	}
+
+	if len(events) == 0 {
+		c.JSON(400, gin.H{"error": "Event batch is empty"})
+		return
+	}
+
+	if len(events) > maxBatchSize {
+		c.JSON(413, gin.H{"error": "Event batch is too large", "max": maxBatchSize})
+		return
+	}
+
+	var errors []eventError
+	for i, event := range events {
+		if err := Validate(event); err != nil {
+			errors = append(errors, eventError{Index: i, Error: err.Error()})
+		}
+
+		if event.EventID == "" {
+			events[i].EventID = EventID(event)
+		}
+	}
+
+	if len(errors) > 0 {
+		c.JSON(400, gin.H{"error": "Event batch failed validation", "events": errors})
+		return
+	}
+
+	result, err := Store.InsertEvents(c, events)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(result.Inserted) > 0 {
+		for _, hook := range hooks {
+			if err := hook(result.Inserted); err != nil {
+				log.Println("Failed to update derived data... rebuild it to catch up. ", err)
+			}
+		}
+	}
+
+	c.JSON(201, gin.H{"accepted": len(result.Inserted), "skipped": result.Skipped})
+}
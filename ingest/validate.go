@@ -0,0 +1,87 @@
+package ingest
+
+import (
+	"LeaderboardsBackend/util"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// required lists the fields each analytic event type must carry on top of the
+// fields shared by every event.
+var required = map[string]func(event util.MongoResult) []string{
+	"Score": func(event util.MongoResult) []string {
+		return missing(map[string]bool{
+			"score_field": event.ScoreField != "",
+			"player_uuid": event.PlayerUUID != "",
+			"player_name": event.PlayerName != "",
+		})
+	},
+	"Death": func(event util.MongoResult) []string {
+		return missing(map[string]bool{
+			"player_uuid":      event.PlayerUUID != "",
+			"player_name":      event.PlayerName != "",
+			"death_event_type": event.DeathEventType != "",
+			"killer_name":      event.KillerUUID == "" || event.KillerName != "",
+		})
+	},
+	"Finish": func(event util.MongoResult) []string {
+		return missing(map[string]bool{
+			"finish_event_type": event.FinishEventType != "",
+			"winners or losers": len(event.Winners)+len(event.Losers) > 0,
+		})
+	},
+	"Timeline": func(event util.MongoResult) []string {
+		return missing(map[string]bool{
+			"phrase": event.Phrase != "",
+		})
+	},
+	"GameInformation": func(event util.MongoResult) []string {
+		return missing(map[string]bool{
+			"world_name": event.WorldName != "",
+		})
+	},
+	"ServerStateChange": func(event util.MongoResult) []string {
+		return missing(map[string]bool{
+			"from": event.From != "",
+			"to":   event.To != "",
+		})
+	},
+}
+
+// Validate checks that an event has a known analytic_event_type and every
+// field the read side relies on for that type.
+func Validate(event util.MongoResult) error {
+	fields, ok := required[event.AnalyticEventType]
+	if !ok {
+		return fmt.Errorf("unknown analytic_event_type %q", event.AnalyticEventType)
+	}
+
+	absent := missing(map[string]bool{
+		"time_code":         event.TimeCode > 0,
+		"server_event_type": event.ServerEventType != "",
+		"game_id":           event.GameID != "",
+		"game_mode_id":      event.GameModeID != "",
+		"instance_id":       event.InstanceID != "",
+	})
+	absent = append(absent, fields(event)...)
+
+	if len(absent) > 0 {
+		return fmt.Errorf("missing required fields: %s", strings.Join(absent, ", "))
+	}
+
+	return nil
+}
+
+func missing(present map[string]bool) []string {
+	var absent []string
+	for field, ok := range present {
+		if !ok {
+			absent = append(absent, field)
+		}
+	}
+
+	sort.Strings(absent)
+
+	return absent
+}
@@ -1,16 +1,14 @@
 package leaderboard
 
 import (
+	"LeaderboardsBackend/store"
 	"LeaderboardsBackend/util"
 	json2 "encoding/json"
-	"fmt"
 	"github.com/gin-gonic/gin"
-	"github.com/simagix/keyhole/mdb"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"log"
-	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 type leaderboardRequest struct {
@@ -21,274 +19,88 @@ type leaderboardRequest struct {
 	User     string `uri:"user" binding:""`
 }
 
-type mongoResult struct {
-	Entries    []mongoEntry `bson:"entries"`
-	TotalCount int32        `bson:"total_count"`
-}
-
-type mongoEntry struct {
-	ID       string           `bson:"uuid"`
-	Name     string           `bson:"name"`
-	Scores   map[string]int32 `bson:"scores"`
-	Position int32            `bson:"position"`
-}
+var Store store.EventStore
 
-var Client *mongo.Client
+func Register(r *gin.Engine, events store.EventStore) {
+	Store = events
 
-func Register(r *gin.Engine, client *mongo.Client) {
-	Client = client
-
-	util.CachedGET(r, "/leaderboard", leaderboardHandler)
-	util.CachedGET(r, "/leaderboard/:game", leaderboardHandler)
-	util.CachedGET(r, "/leaderboard/:game/:mode", leaderboardHandler)
-	util.CachedGET(r, "/leaderboard/:game/:mode/:filter", leaderboardHandler)
-	util.CachedGET(r, "/leaderboard/:game/:mode/:filter/user/:user", leaderboardHandler)
-	util.CachedGET(r, "/leaderboard/:game/:mode/:filter/instance/:instance", leaderboardHandler)
+	util.CachedGETWithPolicy(r, "/leaderboard", Policy, leaderboardHandler)
+	util.CachedGETWithPolicy(r, "/leaderboard/:game", Policy, leaderboardHandler)
+	util.CachedGETWithPolicy(r, "/leaderboard/:game/:mode", Policy, leaderboardHandler)
+	util.CachedGETWithPolicy(r, "/leaderboard/:game/:mode/:filter", Policy, leaderboardHandler)
+	util.CachedGETWithPolicy(r, "/leaderboard/:game/:mode/:filter/user/:user", Policy, leaderboardHandler)
+	util.CachedGETWithPolicy(r, "/leaderboard/:game/:mode/:filter/instance/:instance", Policy, leaderboardHandler)
+	util.CachedGETWithPolicy(r, "/leaderboard/:game/:mode/:filter/around/:user", Policy, Around(leaderboard))
 }
 
 func leaderboardHandler(r *gin.Context) []byte {
-	var err error
-	var collection *mongo.Collection
-	var cur *mongo.Cursor
+	return leaderboard(r, 0)
+}
 
-	var request leaderboardRequest
-	if err = r.ShouldBindUri(&request); err != nil {
-		r.JSON(400, gin.H{"error": err})
-		return nil
-	}
+// outOfOrder counts responses whose entries weren't sorted on their first
+// filter, which would mean the store's ordering is broken.
+var outOfOrder uint64
 
-	page, err := strconv.Atoi(r.Query("page"))
-	length, err := strconv.Atoi(r.Query("length"))
-	if err != nil {
-		page = 1
-		length = 100
+// checkOrder verifies that entries are monotonic on the first filter and
+// logs the running count of violations instead of failing the request.
+func checkOrder(filters []string, result store.LeaderboardResult) {
+	if len(filters) == 0 {
+		return
 	}
 
-	pipeline := `
-		[
-			%s
-			{ 
-				"$project" : {
-					"value" : 1.0, 
-					"score_field" : 1.0, 
-					"player_uuid" : 1.0,
-					"player_name" : 1.0, 
-					"winners" : 1.0, 
-					"losers" : 1.0
-				}
-			}, 
-			{ 
-				"$group" : {
-					"_id" : {
-						"uuid" : "$player_uuid", 
-						"name" : "$player_name", 
-						"score" : "$score_field"
-					}, 
-					"value" : {
-						"$sum" : "$value"
-					}
-				}
-			}, 
-			{ 
-				"$group" : {
-					"_id" : { 
-						"uuid" : "$_id.uuid", 
-						"name" : "$_id.name" 
-					}, 
-					"scores" : {
-						"$push" : {
-							"score" : "$_id.score", 
-							"value" : "$value"
-						}
-					}
-				}
-			}, 
-			{ 
-				"$project" : {
-					"uuid": "$_id.uuid",
-    				"name": "$_id.name", 
-					"scores" : {
-						"$arrayToObject" : {
-							"$map" : {
-								"input" : "$scores", 
-								"as" : "el", 
-								"in" : {
-									"k" : "$$el.score", 
-									"v" : "$$el.value"
-								}
-							}
-						}
-					}
-				}
-			},
- 			%s
-			{ 
-				"$group" : {
-					"_id" : null, 
-					"items" : {
-						"$push" : "$$ROOT"
-					}
-				}
-			}, 
-			{ 
-				"$unwind" : {
-					"path" : "$items", 
-					"includeArrayIndex" : "items.position", 
-					"preserveNullAndEmptyArrays" : false
-				}
-			}, 
-			{ 
-				"$replaceRoot" : {
-					"newRoot" : "$items"
-				}
-			}, 
-			%s
-			{ 
-				"$group" : {
-					"_id" : null, 
-					"entries" : {
-						"$push" : "$$ROOT"
-					}, 
-					"count" : {
-						"$sum" : 1.0
-					}
-				}
-			}, 
-			{ 
-				"$project" : {
-					"entries" : {
-						"$slice" : [
-							"$entries", 
-							%d, 
-							%d
-						]
-					}, 
-					"total_count" : "$count"
-				}
-			}
-    	]`
+	field := strings.TrimPrefix(filters[0], "-")
+	ascending := strings.HasPrefix(filters[0], "-")
 
-	match := `
-			{ 
-				"$match" : {
-					%s
-					%s
-					%s
-					"analytic_event_type" : "Score"
-				}
-			}, `
+	for i := 1; i < len(result.Entries); i++ {
+		prev, next := result.Entries[i-1].Scores[field], result.Entries[i].Scores[field]
+		if (ascending && next < prev) || (!ascending && next > prev) {
+			count := atomic.AddUint64(&outOfOrder, 1)
+			log.Printf("Leaderboard out of order on %s at position %d (%d responses so far).", filters[0], result.Entries[i].Position, count)
 
-	instanceMatch := ""
-	if request.Instance != "" {
-		instanceMatch = fmt.Sprintf("\"instance_id\": \"%s\",", request.Instance)
+			return
+		}
 	}
+}
 
-	modeMatch := ""
-	if request.Mode != "" {
-		modeMatch = fmt.Sprintf("\"game_mode_id\": \"%s\",", request.Mode)
-	}
+func leaderboard(r *gin.Context, around int) []byte {
+	var err error
 
-	gameMatch := ""
-	if request.Game != "" {
-		gameMatch = fmt.Sprintf("\"game_id\": \"%s\",", request.Game)
+	var request leaderboardRequest
+	if err = r.ShouldBindUri(&request); err != nil {
+		r.JSON(400, gin.H{"error": err})
+		return nil
 	}
 
-	match = fmt.Sprintf(match, instanceMatch, modeMatch, gameMatch)
-
-	sort := ""
-	if request.Filter != "" {
-		filters := strings.Split(request.Filter, ",")
-		sort = `
-			{
-				"$match" : {
-					%s
-				}
-			},
-			{ 
-            	"$sort" : {
-                	%s
-            	}
-        	},`
-		sortTemplate := ``
-		matchTemplate := ``
-
-		for i, filter := range filters {
-			if strings.HasPrefix(filter, "-") {
-				if i == 0 {
-					matchTemplate += "\"scores." + filter[1:] + "\" : { \"$exists\" : true, \"$ne\" : null }"
-				}
-
-				sortTemplate += "\"scores." + filter[1:] + "\" : 1.0"
-			} else {
-				if i == 0 {
-					matchTemplate += "\"scores." + filter + "\" : { \"$exists\" : true, \"$ne\" : null }"
-				}
-
-				sortTemplate += "\"scores." + filter + "\" : -1.0"
-			}
+	util.Tag(r, util.ModeTag(request.Game, request.Mode))
 
-			if i+1 != len(filters) {
-				sortTemplate += ","
-			}
-		}
-		sort = fmt.Sprintf(sort, matchTemplate, sortTemplate)
+	window, err := parseWindow(r, time.Now())
+	if err != nil {
+		r.JSON(400, gin.H{"error": err.Error()})
+		return nil
 	}
 
-	userMatch := ``
-	if request.User != "" {
-		userMatch += `
-			{ 
-				"$match" : {
-					"uuid" : "%s"
-				}
-			},`
-
-		userMatch = fmt.Sprintf(userMatch, request.User)
+	query := store.LeaderboardQuery{
+		Game:     request.Game,
+		Mode:     request.Mode,
+		Instance: request.Instance,
+		User:     request.User,
+		Around:   around,
+		From:     window.From,
+		To:       window.To,
 	}
 
-	pipeline = fmt.Sprintf(pipeline, match, sort, userMatch, (page*length)-length, length)
-
-	collection = Client.Database("Analytics").Collection("Events")
-	opts := options.Aggregate()
-	opts.SetAllowDiskUse(true)
-	if cur, err = collection.Aggregate(r, mdb.MongoPipeline(pipeline), opts); err != nil {
-		r.JSON(500, gin.H{"err": err})
+	if err = ParseQuery(r, request.Filter, &query); err != nil {
+		r.JSON(400, gin.H{"error": err.Error()})
 		return nil
 	}
 
-	var result mongoResult
-	for cur.Next(r) {
-		err := cur.Decode(&result)
-		if err != nil {
-			r.JSON(500, gin.H{"err": err})
-			return nil
-		}
+	result, err := Store.Leaderboard(r, query)
+	if err != nil {
+		r.JSON(500, gin.H{"err": err.Error()})
+		return nil
 	}
 
-	var prev int32
-	isFirst := true;
-	for _, v := range result.Entries {
-		filters := strings.Split(request.Filter, ",")
-
-		if strings.HasPrefix(filters[0], "-") {
-			if !isFirst && v.Scores[filters[0][1:]] < prev {
-				fmt.Println("true > ", v.Scores[filters[0][1:]], " | ", prev)
-				return leaderboardHandler(r)
-			}
-
-			isFirst = false
-			prev = v.Scores[filters[0][1:]]
-		} else {
-			if !isFirst && v.Scores[filters[0]] > prev {
-				fmt.Println("true < ", v.Scores[filters[0]], " | ", prev)
-				return leaderboardHandler(r)
-			}
-
-			isFirst = false
-			prev = v.Scores[filters[0]]
-		}
-
-	}
+	checkOrder(query.Filters, result)
 
 	json, err := json2.MarshalIndent(result, "", "    ")
 	if err != nil {
@@ -0,0 +1,78 @@
+package leaderboard
+
+import (
+	"LeaderboardsBackend/fields"
+	"LeaderboardsBackend/store"
+	"LeaderboardsBackend/util"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"strconv"
+	"time"
+)
+
+// Policy caches every leaderboard, regular or seasonal. Leaderboards are
+// expensive to aggregate, so a slightly old board is served while the next
+// one is computed.
+var Policy = util.CachePolicy{TTL: 5 * time.Minute, Stale: 10 * time.Minute, Refresh: true}
+
+// defaultAround and maxAround bound how many neighbours an around route
+// returns on each side of the player.
+const (
+	defaultAround = 5
+	maxAround     = 50
+)
+
+// Around wraps a leaderboard handler for an around route, passing it the
+// count query param: how many entries to return directly above and below the
+// player.
+func Around(leaderboard func(c *gin.Context, around int) []byte) func(c *gin.Context) []byte {
+	return func(c *gin.Context) []byte {
+		count := defaultAround
+		if value := c.Query("count"); value != "" {
+			var err error
+			if count, err = strconv.Atoi(value); err != nil || count < 1 || count > maxAround {
+				c.JSON(400, gin.H{"error": "Invalid count: " + value, "max": maxAround})
+				return nil
+			}
+		}
+
+		return leaderboard(c, count)
+	}
+}
+
+// ParseQuery fills in the query params every leaderboard route accepts: page
+// and length, rank and tiebreak, min_games and the filter path segment.
+func ParseQuery(c *gin.Context, filter string, query *store.LeaderboardQuery) error {
+	page, err := strconv.Atoi(c.Query("page"))
+	length, err := strconv.Atoi(c.Query("length"))
+	if err != nil {
+		page = 1
+		length = 100
+	}
+
+	query.Page, query.Length = page, length
+
+	query.Ranking, query.Tiebreak = c.Query("rank"), c.Query("tiebreak")
+	if err = store.ValidateRanking(query.Ranking, query.Tiebreak); err != nil {
+		return err
+	}
+
+	if err = fields.ParseFilters(filter, query); err != nil {
+		return err
+	}
+
+	// min_games can raise a metric's threshold but never lower it.
+	if value := c.Query("min_games"); value != "" {
+		minGames, err := strconv.Atoi(value)
+		if err != nil || minGames < 0 {
+			return fmt.Errorf("Invalid min_games: %s", value)
+		}
+
+		if minGames > query.MinGames {
+			query.MinGames = minGames
+		}
+		query.Outcomes = true
+	}
+
+	return nil
+}
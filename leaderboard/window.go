@@ -0,0 +1,132 @@
+package leaderboard
+
+import (
+	"LeaderboardsBackend/store"
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// timeRange bounds the Score events counted towards a leaderboard, in
+// time_code milliseconds since the Unix epoch. From is inclusive and To is
+// exclusive; a zero bound leaves that side open.
+type timeRange struct {
+	From int64
+	To   int64
+}
+
+// parseWindow reads the window, from, to and tz query params. A named window
+// is the current day, week (starting Monday) or month in the requested
+// timezone, which defaults to LEADERBOARD_TIMEZONE and then UTC; explicit
+// from/to bounds narrow it further. from is inclusive and to is exclusive, so
+// from=2019-06-01&to=2019-06-02 covers exactly June 1st.
+func parseWindow(c *gin.Context, now time.Time) (timeRange, error) {
+	var window timeRange
+
+	zone := c.Query("tz")
+	if zone == "" {
+		zone = os.Getenv("LEADERBOARD_TIMEZONE")
+	}
+
+	location := time.UTC
+	if zone != "" {
+		var err error
+		if location, err = time.LoadLocation(zone); err != nil {
+			return window, fmt.Errorf("unknown timezone %q", zone)
+		}
+	}
+
+	now = now.In(location)
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+
+	switch name := c.Query("window"); name {
+	case "":
+	case "daily":
+		window = between(start, start.AddDate(0, 0, 1))
+	case "weekly":
+		start = start.AddDate(0, 0, -(int(start.Weekday())+6)%7)
+		window = between(start, start.AddDate(0, 0, 7))
+	case "monthly":
+		start = start.AddDate(0, 0, 1-start.Day())
+		window = between(start, start.AddDate(0, 1, 0))
+	default:
+		return window, fmt.Errorf("unknown window %q", name)
+	}
+
+	if from := c.Query("from"); from != "" {
+		value, err := parseTimeCode(from, location)
+		if err != nil {
+			return window, fmt.Errorf("invalid from: %v", err)
+		}
+
+		if value > window.From {
+			window.From = value
+		}
+	}
+
+	if to := c.Query("to"); to != "" {
+		value, err := parseTimeCode(to, location)
+		if err != nil {
+			return window, fmt.Errorf("invalid to: %v", err)
+		}
+
+		if window.To == 0 || value < window.To {
+			window.To = value
+		}
+	}
+
+	if window.To != 0 && window.From >= window.To {
+		return window, fmt.Errorf("from must be before to")
+	}
+
+	return window, nil
+}
+
+func between(from, to time.Time) timeRange {
+	return timeRange{From: millis(from), To: millis(to)}
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// parseTimeCode accepts a time_code in Unix milliseconds, an RFC 3339
+// timestamp or a plain date, which is read as midnight in location.
+func parseTimeCode(value string, location *time.Location) (int64, error) {
+	if code, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return code, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return millis(t), nil
+	}
+
+	t, err := time.ParseInLocation("2006-01-02", value, location)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a time_code, RFC 3339 timestamp or date", value)
+	}
+
+	return millis(t), nil
+}
+
+// earliestMillis is 1973 in milliseconds but the year 5138 in seconds, so any
+// time_code below it can't be in milliseconds.
+const earliestMillis = 100000000000
+
+// CheckTimeCodes warns when the newest finished game's time_code doesn't look
+// like Unix milliseconds, since windows and from/to would then match nothing.
+func CheckTimeCodes(ctx context.Context, events store.Backend) {
+	games, err := events.GameList(ctx, store.GameListQuery{Page: 1, Length: 1})
+	if err != nil {
+		log.Println("Failed to check time codes: ", err)
+		return
+	}
+
+	if len(games) > 0 && games[0].EndTime < earliestMillis {
+		log.Printf("The newest game ended at time_code %d, which isn't Unix milliseconds... leaderboard windows won't match it.", games[0].EndTime)
+	}
+}
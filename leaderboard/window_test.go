@@ -0,0 +1,108 @@
+package leaderboard
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// window parses the window of a request with the given query string.
+func window(query string, now time.Time) (timeRange, error) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/leaderboard/arena?"+query, nil)
+
+	return parseWindow(c, now)
+}
+
+func at(value string) int64 {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		panic(err)
+	}
+
+	return millis(t)
+}
+
+func TestParseWindow(t *testing.T) {
+	// A Wednesday afternoon in UTC, which is already Thursday in Tokyo.
+	wednesday := time.Date(2019, time.June, 12, 15, 0, 0, 0, time.UTC)
+	sunday := time.Date(2019, time.June, 16, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		query string
+		now   time.Time
+		want  timeRange
+	}{
+		{"", wednesday, timeRange{}},
+		{"window=daily", wednesday, timeRange{at("2019-06-12T00:00:00Z"), at("2019-06-13T00:00:00Z")}},
+		{"window=weekly", wednesday, timeRange{at("2019-06-10T00:00:00Z"), at("2019-06-17T00:00:00Z")}},
+		{"window=weekly", sunday, timeRange{at("2019-06-10T00:00:00Z"), at("2019-06-17T00:00:00Z")}},
+		{"window=monthly", wednesday, timeRange{at("2019-06-01T00:00:00Z"), at("2019-07-01T00:00:00Z")}},
+		{"window=daily&tz=America/New_York", wednesday, timeRange{at("2019-06-12T04:00:00Z"), at("2019-06-13T04:00:00Z")}},
+		{"window=daily&tz=Asia/Tokyo", wednesday, timeRange{at("2019-06-12T15:00:00Z"), at("2019-06-13T15:00:00Z")}},
+		{"window=weekly&tz=Asia/Tokyo", sunday, timeRange{at("2019-06-09T15:00:00Z"), at("2019-06-16T15:00:00Z")}},
+		{"from=2019-06-01&to=2019-06-02", wednesday, timeRange{at("2019-06-01T00:00:00Z"), at("2019-06-02T00:00:00Z")}},
+		{"from=2019-06-01&tz=Asia/Tokyo", wednesday, timeRange{at("2019-05-31T15:00:00Z"), 0}},
+		{"from=1000&to=2000", wednesday, timeRange{1000, 2000}},
+		{"to=2019-06-02T12:00:00%2B02:00", wednesday, timeRange{0, at("2019-06-02T10:00:00Z")}},
+		{"window=monthly&from=2019-06-10&to=2019-08-01", wednesday, timeRange{at("2019-06-10T00:00:00Z"), at("2019-07-01T00:00:00Z")}},
+		{"window=daily&from=2019-05-01", wednesday, timeRange{at("2019-06-12T00:00:00Z"), at("2019-06-13T00:00:00Z")}},
+	}
+
+	for _, test := range tests {
+		got, err := window(test.query, test.now)
+		if err != nil {
+			t.Errorf("%q: %v", test.query, err)
+			continue
+		}
+
+		if got != test.want {
+			t.Errorf("%q at %v: got %+v, want %+v", test.query, test.now, got, test.want)
+		}
+	}
+}
+
+func TestParseWindowDefaultTimezone(t *testing.T) {
+	os.Setenv("LEADERBOARD_TIMEZONE", "Asia/Tokyo")
+	defer os.Unsetenv("LEADERBOARD_TIMEZONE")
+
+	now := time.Date(2019, time.June, 12, 15, 0, 0, 0, time.UTC)
+
+	got, err := window("window=daily", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (timeRange{at("2019-06-12T15:00:00Z"), at("2019-06-13T15:00:00Z")}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// An explicit tz still wins.
+	got, err = window("window=daily&tz=UTC", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (timeRange{at("2019-06-12T00:00:00Z"), at("2019-06-13T00:00:00Z")}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestParseWindowErrors(t *testing.T) {
+	queries := []string{
+		"tz=Nowhere/Special",
+		"window=yearly",
+		"from=yesterday",
+		"to=2019-13-01",
+		"from=2019-06-02&to=2019-06-01",
+		"from=2019-06-01&to=2019-06-01",
+		"window=daily&from=2019-07-01",
+	}
+
+	now := time.Date(2019, time.June, 12, 15, 0, 0, 0, time.UTC)
+	for _, query := range queries {
+		if got, err := window(query, now); err == nil {
+			t.Errorf("%q: got %+v, want an error", query, got)
+		}
+	}
+}
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"LeaderboardsBackend/fields"
 	"LeaderboardsBackend/game"
+	"LeaderboardsBackend/ingest"
 	"LeaderboardsBackend/leaderboard"
+	"LeaderboardsBackend/materialize"
+	"LeaderboardsBackend/season"
 	"LeaderboardsBackend/statistics"
+	"LeaderboardsBackend/store"
 	"LeaderboardsBackend/user"
 	"LeaderboardsBackend/util"
+	"LeaderboardsBackend/worker"
 	"context"
 	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
@@ -20,29 +27,153 @@ func main() {
 	r := gin.Default()
 	gin.ForceConsoleColor()
 
-	client, _ := mongo.NewClient(options.Client().ApplyURI(os.Getenv("MONGO_URI")))
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	client.Connect(ctx)
-	err := client.Ping(ctx, readpref.Primary())
-	if err != nil {
-		log.Fatal("Failed to connect to mongo: ", err)
+	var events store.Backend
+	var mongoStore *store.MongoStore
+	if fixture := os.Getenv("EVENTS_FILE"); fixture != "" {
+		memory, err := store.LoadMemoryStore(fixture)
+		if err != nil {
+			log.Fatal("Failed to load events fixture: ", err)
+		}
+
+		events = memory
+	} else {
+		client := connectMongo()
+		defer client.Disconnect(context.Background())
+
+		mongoStore = store.NewMongoStore(client)
+		go ensureIndexes(context.Background(), mongoStore)
+
+		events = mongoStore
+		go leaderboard.CheckTimeCodes(context.Background(), mongoStore)
 	}
 
-	defer client.Disconnect(ctx)
 	util.SetupCache()
 
+	if registry := os.Getenv("FIELDS_FILE"); registry != "" {
+		if err := fields.Load(registry); err != nil {
+			log.Fatal("Failed to load score fields: ", err)
+		}
+	}
+
+	var seasons []season.Season
+	if registry := os.Getenv("SEASONS_FILE"); registry != "" {
+		var err error
+		if seasons, err = season.LoadSeasons(registry); err != nil {
+			log.Fatal("Failed to load seasons: ", err)
+		}
+
+		go season.Watch(events, seasons, time.Minute)
+	}
+
+	// With EVENT_STREAM set, derived data follows the Events collection
+	// itself, so events written by other producers are picked up too and
+	// ingestion no longer runs the hooks inline.
+	stream := os.Getenv("EVENT_STREAM") != "" && mongoStore != nil
+	if os.Getenv("EVENT_STREAM") != "" && !stream {
+		log.Println("EVENT_STREAM needs mongo... running hooks on ingestion instead.")
+	}
+
+	// hooks keep derived data in step with new events; rebuilds recompute it
+	// when events may have been missed. Cached responses are invalidated last
+	// so they aren't recomputed from stale rollups. Player rollups and game
+	// summaries are only kept, and served, when the stream sees every event.
+	var hooks []func(events []util.MongoResult) error
+	var rebuilds []func()
+	if stream {
+		hooks = append(hooks, user.UpdateStats, game.UpdateSummaries)
+		rebuilds = append(rebuilds, user.RebuildStats, game.RebuildSummaries)
+	}
+
+	// Leaderboards can be served from Redis sorted sets kept up to date as
+	// events are ingested, instead of aggregating on every request. Boards
+	// are only built once; after that they follow the hooks, or a rebuild.
+	boards := events
+	if os.Getenv("MATERIALIZE") != "" {
+		if !stream {
+			log.Println("MATERIALIZE without EVENT_STREAM only sees events posted to this API... rebuild the boards after writing events elsewhere.")
+		}
+
+		materialized := materialize.Wrap(events, redis.NewClient(&redis.Options{Addr: os.Getenv("REDIS")}))
+		hooks = append(hooks, materialized.Apply)
+		rebuilds = append(rebuilds, materialized.RebuildAndLog)
+		materialized.Register(r)
+		go materialized.RebuildMissingAndLog()
+
+		boards = materialized
+	}
+
+	hooks = append(hooks, util.InvalidateEvents)
+
+	if stream {
+		tail := &worker.Worker{Events: mongoStore, Hooks: hooks, Rebuilds: rebuilds}
+		go tail.Run()
+	} else {
+		for _, hook := range hooks {
+			ingest.OnInserted(hook)
+		}
+	}
+
 	r.GET("/", HomeHandler)
 	r.Group("/v1")
 	{
-		game.Register(r, client)
-		leaderboard.Register(r, client)
-		user.Register(r, client)
-		statistics.Register(r, client)
+		game.Register(r, events, stream)
+		if stream {
+			go game.EnsureSummaries()
+		}
+		leaderboard.Register(r, boards)
+		user.Register(r, events, stream)
+		if stream {
+			go user.EnsureStats()
+		}
+		statistics.Register(r, events)
+		season.Register(r, events, seasons)
+		ingest.Register(r, events)
+		util.RegisterCacheRoutes(r)
 	}
 
 	r.Run()
 }
 
+func connectMongo() *mongo.Client {
+	client, _ := mongo.NewClient(options.Client().ApplyURI(os.Getenv("MONGO_URI")))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client.Connect(ctx)
+	err := client.Ping(ctx, readpref.Primary())
+	if err != nil {
+		log.Fatal("Failed to connect to mongo: ", err)
+	}
+
+	return client
+}
+
+// ensureIndexes creates the missing indexes, then logs any declared index
+// that still doesn't exist or hasn't been used since the server started
+// counting, the same findings as leaderboards-admin indexes.
+func ensureIndexes(ctx context.Context, events *store.MongoStore) {
+	if err := events.EnsureIndexes(ctx); err != nil {
+		log.Println("Failed to create indexes: ", err)
+	}
+
+	report, err := events.IndexReport(ctx)
+	if err != nil {
+		log.Println("Failed to check indexes: ", err)
+		return
+	}
+
+	for _, index := range report {
+		switch {
+		case !index.Declared:
+			continue
+		case !index.Exists:
+			log.Printf("Index %s.%s is missing... %s will scan the collection.", index.Collection, index.Name, index.Purpose)
+		case index.Unused():
+			log.Printf("Index %s.%s hasn't been used since %s (%s).", index.Collection, index.Name, index.Since.Format(time.RFC3339), index.Purpose)
+		}
+	}
+}
+
 func HomeHandler(c *gin.Context) {
 	c.JSON(200, gin.H{
 		"message": "Home",
@@ -0,0 +1,262 @@
+package materialize
+
+import (
+	"LeaderboardsBackend/store"
+	"context"
+	"github.com/go-redis/redis"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Materialized boards keep each player's summed score for one field of one
+// game mode in a Redis sorted set, updated as events are ingested. Scores are
+// stored negated so ZRANGE walks the board from the highest score down, with
+// ties in ascending uuid order just like store rankings.
+//
+// A board is only read once it has been rebuilt from the event store, which
+// adds it to the built set; until then queries fall back to aggregation.
+const (
+	prefix   = "leaderboards.mat."
+	builtKey = prefix + "built"
+	namesKey = prefix + "names"
+)
+
+// keyPart escapes a game, mode or field so a "." inside it can't shift the
+// parts of a key.
+func keyPart(value string) string {
+	return strings.Replace(url.PathEscape(value), ".", "%2E", -1)
+}
+
+func board(game, mode string) string {
+	return keyPart(game) + "." + keyPart(mode)
+}
+
+// fieldsKey holds the set of fields materialized for a board.
+func fieldsKey(game, mode string) string {
+	return prefix + board(game, mode)
+}
+
+func scoreKey(game, mode, field string) string {
+	return prefix + board(game, mode) + "." + keyPart(field)
+}
+
+// Store answers eligible leaderboard queries from materialized boards and
+// passes everything else on to the wrapped store.
+type Store struct {
+	store.Backend
+	client *redis.Client
+}
+
+func Wrap(events store.Backend, client *redis.Client) *Store {
+	return &Store{Backend: events, client: client}
+}
+
+// Eligible reports whether a query can be answered from a materialized board:
+// one descending filter over a whole game mode, ranked ordinally or by
+// competition with the default tiebreak, with every field summed since only
+// sums are materialized.
+func Eligible(query store.LeaderboardQuery) bool {
+	if query.Game == "" || query.Mode == "" || query.Instance != "" || query.From != 0 || query.To != 0 {
+		return false
+	}
+
+	if len(query.Filters) != 1 || strings.HasPrefix(query.Filters[0], "-") {
+		return false
+	}
+
+	for _, aggregation := range query.Aggregations {
+		if aggregation != store.AggregateSum {
+			return false
+		}
+	}
+
+	if len(query.Metrics) > 0 || query.Outcomes || query.MinGames > 0 {
+		return false
+	}
+
+	switch query.Ranking {
+	case "", store.RankOrdinal, store.RankCompetition:
+	default:
+		return false
+	}
+
+	return query.Tiebreak == "" || query.Tiebreak == store.TiebreakUUID
+}
+
+func (s *Store) Leaderboard(ctx context.Context, query store.LeaderboardQuery) (store.LeaderboardResult, error) {
+	if Eligible(query) {
+		built, err := s.client.SIsMember(builtKey, board(query.Game, query.Mode)).Result()
+		if err == nil && built {
+			result, err := s.leaderboard(query)
+			if err == nil {
+				return result, nil
+			}
+
+			log.Println("Failed to read materialized leaderboard... aggregating instead. ", err)
+		} else if err != nil {
+			log.Println("Failed to check materialized leaderboards... aggregating instead. ", err)
+		}
+	}
+
+	return s.Backend.Leaderboard(ctx, query)
+}
+
+// leaderboard reads a page, a single player or a player's neighbours off a
+// sorted set, each in O(log n) plus the entries returned, and then the
+// entries' other fields off their own sorted sets.
+func (s *Store) leaderboard(query store.LeaderboardQuery) (store.LeaderboardResult, error) {
+	var result store.LeaderboardResult
+
+	key := scoreKey(query.Game, query.Mode, query.Filters[0])
+
+	total, err := s.client.ZCard(key).Result()
+	if err != nil {
+		return result, err
+	}
+
+	start := int64(store.Offset(query.Page, query.Length))
+	if start < 0 {
+		start = 0
+	}
+
+	stop := start + int64(query.Length) - 1
+	if query.Length < 0 {
+		stop = -1
+	}
+
+	if query.User != "" {
+		rank, err := s.client.ZRank(key, query.User).Result()
+		if err == redis.Nil {
+			if query.Around > 0 {
+				result.TotalCount = int32(total)
+			}
+
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+
+		start, stop = rank, rank
+		if query.Around > 0 {
+			start, stop = rank-int64(query.Around), rank+int64(query.Around)
+			if start < 0 {
+				start = 0
+			}
+		}
+	}
+
+	scores, err := s.client.ZRangeWithScores(key, start, stop).Result()
+	if err != nil || len(scores) == 0 {
+		return result, err
+	}
+
+	positions, err := s.positions(key, query.Ranking, start, scores)
+	if err != nil {
+		return result, err
+	}
+
+	uuids := make([]string, len(scores))
+	for i, score := range scores {
+		uuids[i] = score.Member.(string)
+	}
+
+	names, err := s.client.HMGet(namesKey, uuids...).Result()
+	if err != nil {
+		return result, err
+	}
+
+	fields, err := s.scores(query.Game, query.Mode, uuids)
+	if err != nil {
+		return result, err
+	}
+
+	for i, score := range scores {
+		name, _ := names[i].(string)
+		fields[i][query.Filters[0]] = -score.Score
+		result.Entries = append(result.Entries, store.LeaderboardEntry{
+			ID:       uuids[i],
+			Name:     name,
+			Scores:   fields[i],
+			Position: positions[i],
+		})
+	}
+
+	result.TotalCount = int32(total)
+	if query.User != "" && query.Around == 0 {
+		result.TotalCount = 1
+	}
+
+	return result, nil
+}
+
+// scores looks up every materialized field of a board for each player, in
+// one round trip. Fields a player never scored in are left out, as they are
+// when aggregating.
+func (s *Store) scores(game, mode string, uuids []string) ([]map[string]float64, error) {
+	fields, err := s.client.SMembers(fieldsKey(game, mode)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	cmds := make([][]*redis.FloatCmd, len(uuids))
+	_, err = s.client.Pipelined(func(pipe redis.Pipeliner) error {
+		for i, uuid := range uuids {
+			for _, field := range fields {
+				cmds[i] = append(cmds[i], pipe.ZScore(scoreKey(game, mode, field), uuid))
+			}
+		}
+
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	scores := make([]map[string]float64, len(uuids))
+	for i := range uuids {
+		scores[i] = make(map[string]float64)
+		for j, field := range fields {
+			score, err := cmds[i][j].Result()
+			if err == redis.Nil {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			scores[i][field] = -score
+		}
+	}
+
+	return scores, nil
+}
+
+// positions ranks a slice of the board starting at index start. Competition
+// ranks only need the number of players strictly ahead of the first entry.
+func (s *Store) positions(key, ranking string, start int64, scores []redis.Z) ([]int32, error) {
+	positions := make([]int32, len(scores))
+	for i := range scores {
+		positions[i] = int32(start) + int32(i)
+	}
+
+	if ranking != store.RankCompetition {
+		return positions, nil
+	}
+
+	ahead, err := s.client.ZCount(key, "-inf", "("+strconv.FormatFloat(scores[0].Score, 'f', -1, 64)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	positions[0] = int32(ahead)
+	for i := 1; i < len(scores); i++ {
+		if scores[i].Score == scores[i-1].Score {
+			positions[i] = positions[i-1]
+		}
+	}
+
+	return positions, nil
+}
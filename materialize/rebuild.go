@@ -0,0 +1,365 @@
+package materialize
+
+import (
+	"LeaderboardsBackend/store"
+	"LeaderboardsBackend/util"
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// rebuildBatch is how many players are written to Redis per round trip.
+	rebuildBatch = 1000
+	// rebuildTimeout bounds how long a board counts as being rebuilt, should
+	// the process rebuilding it die.
+	rebuildTimeout = time.Hour
+)
+
+// rebuildingKey is set while a board is rebuilt. Updates made meanwhile
+// record their players in pendingKey, so they can be recomputed once the
+// rebuilt board replaces the one they were written to. Both live outside
+// prefix so no game can be named like them.
+func rebuildingKey(game, mode string) string {
+	return "leaderboards.rebuilding." + board(game, mode)
+}
+
+func pendingKey(game, mode string) string {
+	return "leaderboards.pending." + board(game, mode)
+}
+
+// updateScript records players as pending if their board is being rebuilt,
+// and reports whether the board is built. Doing both atomically means every
+// update either lands on the rebuilt board or is recomputed after it.
+var updateScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	redis.call("SADD", KEYS[2], unpack(ARGV, 2))
+	redis.call("PEXPIRE", KEYS[2], redis.call("PTTL", KEYS[1]))
+end
+return redis.call("SISMEMBER", KEYS[3], ARGV[1])
+`)
+
+// finishScript ends a board's rebuild, returning the players updated while it
+// ran.
+var finishScript = redis.NewScript(`
+redis.call("DEL", KEYS[1])
+local players = redis.call("SMEMBERS", KEYS[2])
+redis.call("DEL", KEYS[2])
+return players
+`)
+
+// rebuilding stops two rebuilds in this process from racing.
+var rebuilding sync.Mutex
+
+// Apply is a hook running Update for newly written events.
+func (s *Store) Apply(events []util.MongoResult) error {
+	return s.Update(context.Background(), events)
+}
+
+// Update recomputes the totals of every player with Score events in events,
+// on the boards that are already built, and writes them as absolute scores so
+// applying the same events twice changes nothing. Boards that aren't built yet
+// pick the events up when they are; players updated while their board is
+// rebuilt are recomputed again once it is.
+func (s *Store) Update(ctx context.Context, events []util.MongoResult) error {
+	type mode struct{ game, mode string }
+
+	var modes []mode
+	players := make(map[mode][]string)
+	seen := make(map[string]bool)
+	for _, event := range events {
+		if event.AnalyticEventType != "Score" || event.PlayerUUID == "" {
+			continue
+		}
+
+		key := mode{event.GameID, event.GameModeID}
+		if _, ok := players[key]; !ok {
+			modes = append(modes, key)
+		}
+
+		if member := board(key.game, key.mode) + "." + event.PlayerUUID; !seen[member] {
+			seen[member] = true
+			players[key] = append(players[key], event.PlayerUUID)
+		}
+	}
+
+	for _, key := range modes {
+		args := []interface{}{board(key.game, key.mode)}
+		for _, player := range players[key] {
+			args = append(args, player)
+		}
+
+		keys := []string{rebuildingKey(key.game, key.mode), pendingKey(key.game, key.mode), builtKey}
+		built, err := updateScript.Run(s.client, keys, args...).Int()
+		if err != nil {
+			return err
+		}
+
+		if built == 0 {
+			continue
+		}
+
+		if err := s.updatePlayers(ctx, key.game, key.mode, players[key]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// updatePlayers writes the current totals of players to a built board.
+func (s *Store) updatePlayers(ctx context.Context, game, mode string, players []string) error {
+	entries, err := s.Backend.PlayerScores(ctx, store.LeaderboardQuery{Game: game, Mode: mode, Players: players})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.Pipelined(func(pipe redis.Pipeliner) error {
+		for id, player := range totals(entries) {
+			for field, sum := range player.sums {
+				pipe.ZAdd(scoreKey(game, mode, field), redis.Z{Score: -sum, Member: id})
+				pipe.SAdd(fieldsKey(game, mode), field)
+			}
+
+			pipe.HSet(namesKey, id, player.name)
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+// playerTotals is what a board holds for one player.
+type playerTotals struct {
+	name     string
+	achieved int64
+	sums     map[string]float64
+}
+
+// totals merges the entries PlayerScores returns for each uuid and name into
+// one per uuid, since boards are keyed by uuid alone: a player who played
+// under several names has their sums added up and shows their latest name.
+func totals(entries []store.LeaderboardEntry) map[string]*playerTotals {
+	players := make(map[string]*playerTotals)
+	for _, entry := range entries {
+		player, ok := players[entry.ID]
+		if !ok {
+			player = &playerTotals{sums: make(map[string]float64)}
+			players[entry.ID] = player
+		}
+
+		var achieved int64
+		for field, stats := range entry.Stats {
+			player.sums[field] += stats.Sum
+			if stats.Achieved > achieved {
+				achieved = stats.Achieved
+			}
+		}
+
+		if !ok || achieved > player.achieved || achieved == player.achieved && entry.Name > player.name {
+			player.name, player.achieved = entry.Name, achieved
+		}
+	}
+
+	return players
+}
+
+// Rebuild recomputes every board from the event store. Each field is written
+// to a temporary key and renamed over the live one, so readers never see a
+// half-built board.
+func (s *Store) Rebuild(ctx context.Context) error {
+	return s.rebuild(ctx, false)
+}
+
+// RebuildMissing builds the boards that have never been built, e.g. those of
+// new game modes, leaving built ones alone.
+func (s *Store) RebuildMissing(ctx context.Context) error {
+	return s.rebuild(ctx, true)
+}
+
+func (s *Store) rebuild(ctx context.Context, missing bool) error {
+	rebuilding.Lock()
+	defer rebuilding.Unlock()
+
+	games, err := s.Backend.Games(ctx)
+	if err != nil {
+		return err
+	}
+
+	skip := make(map[string]bool)
+	if missing {
+		built, err := s.client.SMembers(builtKey).Result()
+		if err != nil {
+			return err
+		}
+
+		for _, name := range built {
+			skip[name] = true
+		}
+	}
+
+	for _, modes := range games {
+		game, ok := modes.Game.(string)
+		if !ok {
+			continue
+		}
+
+		for _, mode := range modes.GameModes {
+			if skip[board(game, mode)] {
+				continue
+			}
+
+			if err := s.rebuildBoard(ctx, game, mode); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// rebuildBoard rebuilds one board, then recomputes the players whose updates
+// went to the board it replaced.
+func (s *Store) rebuildBoard(ctx context.Context, game, mode string) error {
+	if err := s.client.Set(rebuildingKey(game, mode), 1, rebuildTimeout).Err(); err != nil {
+		return err
+	}
+
+	err := s.replaceBoard(ctx, game, mode)
+
+	keys := []string{rebuildingKey(game, mode), pendingKey(game, mode)}
+	pending, finishErr := finishScript.Run(s.client, keys).Result()
+	if err != nil {
+		return err
+	}
+	if finishErr != nil {
+		return finishErr
+	}
+
+	var players []string
+	for _, player := range pending.([]interface{}) {
+		players = append(players, player.(string))
+	}
+
+	if len(players) > 0 {
+		if err := s.updatePlayers(ctx, game, mode, players); err != nil {
+			return err
+		}
+	}
+
+	if _, err := util.InvalidateTags(util.ModeTag(game, mode)); err != nil {
+		log.Println("Failed to invalidate rebuilt leaderboard... ", err)
+	}
+
+	return nil
+}
+
+// replaceBoard writes a board's fields from the event store and swaps them in.
+func (s *Store) replaceBoard(ctx context.Context, game, mode string) error {
+	entries, err := s.Backend.PlayerScores(ctx, store.LeaderboardQuery{Game: game, Mode: mode})
+	if err != nil {
+		return err
+	}
+
+	scores := make(map[string][]redis.Z)
+	names := make(map[string]interface{})
+	for id, player := range totals(entries) {
+		for field, sum := range player.sums {
+			scores[field] = append(scores[field], redis.Z{Score: -sum, Member: id})
+		}
+
+		names[id] = player.name
+	}
+
+	stale, err := s.client.SMembers(fieldsKey(game, mode)).Result()
+	if err != nil {
+		return err
+	}
+
+	for field, members := range scores {
+		key := scoreKey(game, mode, field)
+		temporary := key + ".rebuild"
+
+		if err := s.client.Del(temporary).Err(); err != nil {
+			return err
+		}
+
+		for start := 0; start < len(members); start += rebuildBatch {
+			end := start + rebuildBatch
+			if end > len(members) {
+				end = len(members)
+			}
+
+			if err := s.client.ZAdd(temporary, members[start:end]...).Err(); err != nil {
+				return err
+			}
+		}
+
+		if err := s.client.Rename(temporary, key).Err(); err != nil {
+			return err
+		}
+	}
+
+	// Fields that no longer have any scores are dropped.
+	var removed []string
+	for _, field := range stale {
+		if _, ok := scores[field]; !ok {
+			removed = append(removed, scoreKey(game, mode, field))
+		}
+	}
+
+	_, err = s.client.Pipelined(func(pipe redis.Pipeliner) error {
+		if len(removed) > 0 {
+			pipe.Del(removed...)
+		}
+
+		pipe.Del(fieldsKey(game, mode))
+		for field := range scores {
+			pipe.SAdd(fieldsKey(game, mode), field)
+		}
+
+		if len(names) > 0 {
+			pipe.HMSet(namesKey, names)
+		}
+
+		pipe.SAdd(builtKey, board(game, mode))
+
+		return nil
+	})
+
+	return err
+}
+
+// Register exposes an on-demand rebuild for admins, guarded by ADMIN_TOKEN.
+// The rebuild runs in the background; its outcome is logged.
+func (s *Store) Register(r *gin.Engine) {
+	r.POST("/v1/materialize/rebuild", util.RequireToken("ADMIN_TOKEN"), func(c *gin.Context) {
+		go s.RebuildAndLog()
+
+		c.JSON(202, gin.H{"status": "rebuilding"})
+	})
+}
+
+// RebuildAndLog runs Rebuild and logs how it went.
+func (s *Store) RebuildAndLog() {
+	if err := s.Rebuild(context.Background()); err != nil {
+		log.Println("Failed to rebuild materialized leaderboards: ", err)
+		return
+	}
+
+	log.Println("Rebuilt materialized leaderboards.")
+}
+
+// RebuildMissingAndLog runs RebuildMissing and logs how it went.
+func (s *Store) RebuildMissingAndLog() {
+	if err := s.RebuildMissing(context.Background()); err != nil {
+		log.Println("Failed to build materialized leaderboards: ", err)
+		return
+	}
+
+	log.Println("Built missing materialized leaderboards.")
+}
@@ -0,0 +1,31 @@
+package materialize
+
+import (
+	"LeaderboardsBackend/store"
+	"reflect"
+	"testing"
+)
+
+func TestTotalsMergesNames(t *testing.T) {
+	entries := []store.LeaderboardEntry{
+		{ID: "1", Name: "new", Stats: map[string]store.ScoreStats{"kills": {Sum: 2, Achieved: 300}}},
+		{ID: "1", Name: "old", Stats: map[string]store.ScoreStats{"kills": {Sum: 5, Achieved: 100}, "wins": {Sum: 1, Achieved: 200}}},
+		{ID: "2", Name: "bob", Stats: map[string]store.ScoreStats{"kills": {Sum: 4, Achieved: 100}}},
+	}
+
+	players := totals(entries)
+	if len(players) != 2 {
+		t.Fatalf("got %d players, want 2", len(players))
+	}
+
+	if want := map[string]float64{"kills": 7, "wins": 1}; !reflect.DeepEqual(players["1"].sums, want) {
+		t.Errorf("got sums %v, want %v", players["1"].sums, want)
+	}
+	if players["1"].name != "new" {
+		t.Errorf("got name %q, want the latest one", players["1"].name)
+	}
+
+	if want := map[string]float64{"kills": 4}; !reflect.DeepEqual(players["2"].sums, want) || players["2"].name != "bob" {
+		t.Errorf("got %q with %v, want bob with %v", players["2"].name, players["2"].sums, want)
+	}
+}
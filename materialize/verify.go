@@ -0,0 +1,132 @@
+package materialize
+
+import (
+	"LeaderboardsBackend/store"
+	"context"
+	"sort"
+)
+
+// Discrepancy is a materialized score that differs from the event store.
+type Discrepancy struct {
+	Game, Mode, Field, Player string
+	Stored, Expected          float64
+}
+
+// Verify compares every built board with the scores recomputed from the
+// event store, returning the differences sorted by board, field and player.
+func (s *Store) Verify(ctx context.Context) ([]Discrepancy, error) {
+	games, err := s.Backend.Games(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []Discrepancy
+	for _, modes := range games {
+		game, ok := modes.Game.(string)
+		if !ok {
+			continue
+		}
+
+		for _, mode := range modes.GameModes {
+			built, err := s.client.SIsMember(builtKey, board(game, mode)).Result()
+			if err != nil {
+				return nil, err
+			}
+
+			if !built {
+				continue
+			}
+
+			differences, err := s.verifyBoard(ctx, game, mode)
+			if err != nil {
+				return nil, err
+			}
+
+			found = append(found, differences...)
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		a, b := found[i], found[j]
+		if a.Game != b.Game {
+			return a.Game < b.Game
+		}
+		if a.Mode != b.Mode {
+			return a.Mode < b.Mode
+		}
+		if a.Field != b.Field {
+			return a.Field < b.Field
+		}
+
+		return a.Player < b.Player
+	})
+
+	return found, nil
+}
+
+func (s *Store) verifyBoard(ctx context.Context, game, mode string) ([]Discrepancy, error) {
+	entries, err := s.Backend.PlayerScores(ctx, store.LeaderboardQuery{Game: game, Mode: mode})
+	if err != nil {
+		return nil, err
+	}
+
+	expected := make(map[string]map[string]float64)
+	for id, player := range totals(entries) {
+		for field, sum := range player.sums {
+			if expected[field] == nil {
+				expected[field] = make(map[string]float64)
+			}
+
+			expected[field][id] = sum
+		}
+	}
+
+	materialized, err := s.client.SMembers(fieldsKey(game, mode)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]bool)
+	for _, field := range materialized {
+		fields[field] = true
+	}
+	for field := range expected {
+		fields[field] = true
+	}
+
+	var found []Discrepancy
+	for field := range fields {
+		scores, err := s.client.ZRangeWithScores(scoreKey(game, mode, field), 0, -1).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		stored := make(map[string]float64, len(scores))
+		for _, score := range scores {
+			stored[score.Member.(string)] = -score.Score
+		}
+
+		players := make(map[string]bool)
+		for player := range stored {
+			players[player] = true
+		}
+		for player := range expected[field] {
+			players[player] = true
+		}
+
+		for player := range players {
+			if stored[player] != expected[field][player] {
+				found = append(found, Discrepancy{
+					Game:     game,
+					Mode:     mode,
+					Field:    field,
+					Player:   player,
+					Stored:   stored[player],
+					Expected: expected[field][player],
+				})
+			}
+		}
+	}
+
+	return found, nil
+}
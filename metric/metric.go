@@ -0,0 +1,239 @@
+package metric
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Expression is a derived metric such as "kills/deaths" or "(wins*100)/games",
+// built from numbers, variables, + - * / and parentheses. Variables are
+// letters, digits and underscores and are resolved when the expression is
+// evaluated.
+type Expression struct {
+	source    string
+	root      node
+	variables []string
+}
+
+type node interface {
+	eval(vars func(name string) float64) float64
+}
+
+type number float64
+
+func (n number) eval(vars func(name string) float64) float64 {
+	return float64(n)
+}
+
+type variable string
+
+func (v variable) eval(vars func(name string) float64) float64 {
+	return vars(string(v))
+}
+
+type negate struct {
+	operand node
+}
+
+func (n negate) eval(vars func(name string) float64) float64 {
+	return -n.operand.eval(vars)
+}
+
+type binary struct {
+	op          byte
+	left, right node
+}
+
+// eval treats x/0 as x, so a player without deaths has a K/D equal to their
+// kills rather than an infinite or undefined one.
+func (b binary) eval(vars func(name string) float64) float64 {
+	left, right := b.left.eval(vars), b.right.eval(vars)
+
+	switch b.op {
+	case '+':
+		return left + right
+	case '-':
+		return left - right
+	case '*':
+		return left * right
+	}
+
+	if right == 0 {
+		return left
+	}
+
+	return left / right
+}
+
+func Parse(source string) (*Expression, error) {
+	p := &parser{source: source, variables: make(map[string]bool)}
+
+	root, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.skipSpace(); p.pos < len(p.source) {
+		return nil, p.errorf("unexpected %q", p.source[p.pos])
+	}
+
+	expression := &Expression{source: source, root: root}
+	for name := range p.variables {
+		expression.variables = append(expression.variables, name)
+	}
+	sort.Strings(expression.variables)
+
+	return expression, nil
+}
+
+// Eval computes the metric, looking variables up with vars.
+func (e *Expression) Eval(vars func(name string) float64) float64 {
+	return e.root.eval(vars)
+}
+
+// Variables lists the variables the expression uses, sorted.
+func (e *Expression) Variables() []string {
+	return e.variables
+}
+
+func (e *Expression) String() string {
+	return e.source
+}
+
+// parser is a recursive descent parser over the grammar
+//
+//	expression = term { ("+" | "-") term }
+//	term       = factor { ("*" | "/") factor }
+//	factor     = number | variable | "-" factor | "(" expression ")"
+type parser struct {
+	source    string
+	pos       int
+	variables map[string]bool
+}
+
+func (p *parser) expression() (node, error) {
+	left, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := p.peek()
+		if op != '+' && op != '-' {
+			return left, nil
+		}
+		p.pos++
+
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+
+		left = binary{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) term() (node, error) {
+	left, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := p.peek()
+		if op != '*' && op != '/' {
+			return left, nil
+		}
+		p.pos++
+
+		right, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+
+		left = binary{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) factor() (node, error) {
+	switch c := p.peek(); {
+	case c == 0:
+		return nil, p.errorf("unexpected end of expression")
+	case c == '-':
+		p.pos++
+
+		operand, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+
+		return negate{operand: operand}, nil
+	case c == '(':
+		p.pos++
+
+		inner, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.peek() != ')' {
+			return nil, p.errorf("missing )")
+		}
+		p.pos++
+
+		return inner, nil
+	case isDigit(c) || c == '.':
+		start := p.pos
+		for p.pos < len(p.source) && (isDigit(p.source[p.pos]) || p.source[p.pos] == '.') {
+			p.pos++
+		}
+
+		value, err := strconv.ParseFloat(p.source[start:p.pos], 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", p.source[start:p.pos])
+		}
+
+		return number(value), nil
+	case isLetter(c):
+		start := p.pos
+		for p.pos < len(p.source) && (isLetter(p.source[p.pos]) || isDigit(p.source[p.pos])) {
+			p.pos++
+		}
+
+		name := p.source[start:p.pos]
+		p.variables[name] = true
+
+		return variable(name), nil
+	default:
+		return nil, p.errorf("unexpected %q", c)
+	}
+}
+
+// peek skips whitespace and returns the next byte, or 0 at the end.
+func (p *parser) peek() byte {
+	p.skipSpace()
+	if p.pos >= len(p.source) {
+		return 0
+	}
+
+	return p.source[p.pos]
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.source) && (p.source[p.pos] == ' ' || p.source[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("metric %q at %d: %s", p.source, p.pos, fmt.Sprintf(format, args...))
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
@@ -0,0 +1,73 @@
+package metric
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	vars := map[string]float64{"kills": 12, "deaths": 4, "wins": 3, "games": 4, "zero": 0}
+	lookup := func(name string) float64 {
+		return vars[name]
+	}
+
+	tests := []struct {
+		source string
+		want   float64
+	}{
+		{"kills/deaths", 3},
+		{"(wins*100)/games", 75},
+		{"kills - deaths * 2", 4},
+		{"(kills - deaths) * 2", 16},
+		{"-kills + 2", -10},
+		{"--kills", 12},
+		{"kills/zero", 12},
+		{"1.5 * games", 6},
+		{"kills / deaths / 3", 1},
+		{"missing + 1", 1},
+	}
+
+	for _, test := range tests {
+		expression, err := Parse(test.source)
+		if err != nil {
+			t.Errorf("%s: %v", test.source, err)
+			continue
+		}
+
+		if got := expression.Eval(lookup); got != test.want {
+			t.Errorf("%s: got %g, want %g", test.source, got, test.want)
+		}
+
+		if expression.String() != test.source {
+			t.Errorf("%s: String() = %q", test.source, expression.String())
+		}
+	}
+}
+
+func TestVariables(t *testing.T) {
+	expression, err := Parse("(wins + kills) / games + kills")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"games", "kills", "wins"}; !reflect.DeepEqual(expression.Variables(), want) {
+		t.Errorf("got %v, want %v", expression.Variables(), want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, source := range []string{
+		"",
+		"kills/",
+		"(kills",
+		"kills)",
+		"kills deaths",
+		"kills % deaths",
+		"1.2.3",
+		"$where",
+	} {
+		if _, err := Parse(source); err == nil {
+			t.Errorf("%q: expected an error", source)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package pipeline
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"regexp"
+)
+
+var fieldPattern = regexp.MustCompile(`^[A-Za-z0-9_\-]+$`)
+
+// Builder assembles an aggregation pipeline out of bson.D stages. Request
+// parameters are always bound as values, never spliced into the document
+// structure, so they cannot introduce operators or extra stages.
+type Builder struct {
+	stages mongo.Pipeline
+}
+
+func New() *Builder {
+	return &Builder{}
+}
+
+// Stage appends an arbitrary stage, e.g. Stage("$count", "total").
+func (b *Builder) Stage(name string, body interface{}) *Builder {
+	b.stages = append(b.stages, bson.D{{Key: name, Value: body}})
+
+	return b
+}
+
+func (b *Builder) Match(filter bson.M) *Builder {
+	return b.Stage("$match", filter)
+}
+
+func (b *Builder) Project(fields bson.M) *Builder {
+	return b.Stage("$project", fields)
+}
+
+// Group appends a $group stage with the given _id and accumulators.
+func (b *Builder) Group(id interface{}, accumulators bson.M) *Builder {
+	group := bson.M{"_id": id}
+	for field, accumulator := range accumulators {
+		group[field] = accumulator
+	}
+
+	return b.Stage("$group", group)
+}
+
+func (b *Builder) Sort(keys bson.D) *Builder {
+	return b.Stage("$sort", keys)
+}
+
+// Skip appends a $skip stage. Negative offsets, which Mongo rejects, are
+// treated as zero.
+func (b *Builder) Skip(n int) *Builder {
+	if n < 0 {
+		n = 0
+	}
+
+	return b.Stage("$skip", int64(n))
+}
+
+func (b *Builder) Limit(n int) *Builder {
+	return b.Stage("$limit", int64(n))
+}
+
+// Unwind appends an $unwind stage on path, recording each element's array
+// index in indexField when it is non-empty.
+func (b *Builder) Unwind(path, indexField string) *Builder {
+	unwind := bson.M{"path": path, "preserveNullAndEmptyArrays": false}
+	if indexField != "" {
+		unwind["includeArrayIndex"] = indexField
+	}
+
+	return b.Stage("$unwind", unwind)
+}
+
+func (b *Builder) ReplaceRoot(newRoot interface{}) *Builder {
+	return b.Stage("$replaceRoot", bson.M{"newRoot": newRoot})
+}
+
+func (b *Builder) Pipeline() mongo.Pipeline {
+	return b.stages
+}
+
+// Include builds a projection keeping only the given fields.
+func Include(fields ...string) bson.M {
+	projection := make(bson.M, len(fields))
+	for _, field := range fields {
+		projection[field] = 1
+	}
+
+	return projection
+}
+
+// Ref turns a field name into a field path expression, e.g. "game_id" into
+// "$game_id".
+func Ref(field string) string {
+	return "$" + field
+}
+
+// Keys is an expression evaluating to the keys of the embedded document at
+// field, or an empty array when the field is missing.
+func Keys(field string) bson.M {
+	return bson.M{"$map": bson.M{
+		"input": bson.M{"$objectToArray": bson.M{"$ifNull": bson.A{Ref(field), bson.M{}}}},
+		"as":    "entry",
+		"in":    "$$entry.k",
+	}}
+}
+
+// ValidField reports whether name is safe to use as part of a field path.
+// Field names taken from requests must pass this check, since a "." or "$"
+// would let the caller address arbitrary fields or operators.
+func ValidField(name string) bool {
+	return fieldPattern.MatchString(name)
+}
+
+// ValidFieldRegex matches the names ValidField accepts, for filtering stored
+// field names inside a query.
+func ValidFieldRegex() primitive.Regex {
+	return primitive.Regex{Pattern: fieldPattern.String()}
+}
+
+// Order is a $sort key on field, descending unless ascending is set.
+func Order(field string, ascending bool) bson.E {
+	if ascending {
+		return bson.E{Key: field, Value: 1}
+	}
+
+	return bson.E{Key: field, Value: -1}
+}
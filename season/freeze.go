@@ -0,0 +1,95 @@
+package season
+
+import (
+	"LeaderboardsBackend/store"
+	"LeaderboardsBackend/util"
+	"context"
+	"log"
+	"time"
+)
+
+// Watch freezes every season once it ends, checking every interval. Seasons
+// already frozen by an earlier run or another replica are left alone.
+func Watch(events store.Backend, seasons []Season, interval time.Duration) {
+	frozen := make(map[string]bool)
+
+	for ; ; time.Sleep(interval) {
+		for _, season := range seasons {
+			if frozen[season.Name] || !season.Ended(time.Now()) {
+				continue
+			}
+
+			ctx := context.Background()
+			done, err := events.Frozen(ctx, season.Name)
+			if err == nil && !done {
+				err = Freeze(ctx, events, season)
+			}
+
+			if err != nil {
+				log.Println("Failed to freeze season "+season.Name+"... retrying later. ", err)
+				continue
+			}
+
+			frozen[season.Name] = true
+		}
+	}
+}
+
+// Freeze stores the final standings of a season, the score stats of each
+// player in each game mode, and drops cached responses that were computed
+// from live events.
+func Freeze(ctx context.Context, events store.Backend, season Season) error {
+	games, err := events.Games(ctx)
+	if err != nil {
+		return err
+	}
+
+	var standings []store.Standing
+	for _, modes := range games {
+		game, ok := modes.Game.(string)
+		if !ok || !season.Includes(game) {
+			continue
+		}
+
+		for _, mode := range modes.GameModes {
+			entries, err := events.PlayerScores(ctx, store.LeaderboardQuery{
+				Game:     game,
+				Mode:     mode,
+				From:     timeCode(season.Start),
+				To:       timeCode(season.End),
+				Outcomes: true,
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, entry := range entries {
+				standings = append(standings, store.Standing{
+					Game:     game,
+					Mode:     mode,
+					ID:       entry.ID,
+					Name:     entry.Name,
+					Stats:    entry.Stats,
+					Outcomes: entry.Outcomes,
+				})
+			}
+		}
+	}
+
+	if err := events.SaveStandings(ctx, season.Name, standings); err != nil {
+		return err
+	}
+
+	log.Printf("Froze season %s with %d standings.", season.Name, len(standings))
+
+	if _, err := util.InvalidateTags(util.SeasonTag(season.Name)); err != nil {
+		log.Println("Failed to invalidate season "+season.Name+"... ", err)
+	}
+
+	return nil
+}
+
+// timeCode converts a time to the millisecond time_code used by events.
+func timeCode(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,80 @@
+package season
+
+import (
+	"LeaderboardsBackend/pipeline"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Season is a named period whose leaderboards only count Score events from
+// Start up to End. An empty Games list includes every game.
+type Season struct {
+	Name  string    `json:"name"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Games []string  `json:"games"`
+}
+
+func (s Season) Includes(game string) bool {
+	if len(s.Games) == 0 {
+		return true
+	}
+
+	for _, g := range s.Games {
+		if g == game {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s Season) Ended(now time.Time) bool {
+	return !now.Before(s.End)
+}
+
+func (s Season) Status(now time.Time) string {
+	switch {
+	case now.Before(s.Start):
+		return "upcoming"
+	case s.Ended(now):
+		return "ended"
+	default:
+		return "active"
+	}
+}
+
+// LoadSeasons reads the season registry, a JSON array of seasons with RFC 3339
+// start and end times.
+func LoadSeasons(path string) ([]Season, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var seasons []Season
+	if err := json.NewDecoder(file).Decode(&seasons); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	seen := make(map[string]bool)
+	for _, season := range seasons {
+		if !pipeline.ValidField(season.Name) {
+			return nil, fmt.Errorf("%s: invalid season name %q", path, season.Name)
+		}
+
+		if seen[season.Name] {
+			return nil, fmt.Errorf("%s: season %q is defined twice", path, season.Name)
+		}
+		seen[season.Name] = true
+
+		if !season.Start.Before(season.End) {
+			return nil, fmt.Errorf("%s: season %q ends before it starts", path, season.Name)
+		}
+	}
+
+	return seasons, nil
+}
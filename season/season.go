@@ -0,0 +1,139 @@
+package season
+
+import (
+	"LeaderboardsBackend/leaderboard"
+	"LeaderboardsBackend/store"
+	"LeaderboardsBackend/util"
+	json2 "encoding/json"
+	"github.com/gin-gonic/gin"
+	"time"
+)
+
+type seasonRequest struct {
+	Season string `uri:"season" binding:""`
+	Game   string `uri:"game" binding:""`
+	Mode   string `uri:"mode" binding:""`
+	Filter string `uri:"filter" binding:""`
+	User   string `uri:"user" binding:""`
+}
+
+type seasonResponse struct {
+	Season
+	Status string `json:"status"`
+}
+
+var Store store.Backend
+
+var Seasons []Season
+
+// Register adds the season routes. They live under /season rather than
+// /leaderboard/season because gin panics when a static "season" segment sits
+// next to the :game param of the regular leaderboard routes.
+func Register(r *gin.Engine, events store.Backend, seasons []Season) {
+	Store = events
+	Seasons = seasons
+
+	// Live season boards change as often as regular leaderboards; frozen
+	// ones are only invalidated once, when the season is frozen.
+	policy := leaderboard.Policy
+
+	util.CachedGETWithPolicy(r, "/seasons", util.CachePolicy{TTL: time.Minute}, seasonsHandler)
+	util.CachedGETWithPolicy(r, "/season/:season/leaderboard/:game", policy, leaderboardHandler)
+	util.CachedGETWithPolicy(r, "/season/:season/leaderboard/:game/:mode", policy, leaderboardHandler)
+	util.CachedGETWithPolicy(r, "/season/:season/leaderboard/:game/:mode/:filter", policy, leaderboardHandler)
+	util.CachedGETWithPolicy(r, "/season/:season/leaderboard/:game/:mode/:filter/user/:user", policy, leaderboardHandler)
+	util.CachedGETWithPolicy(r, "/season/:season/leaderboard/:game/:mode/:filter/around/:user", policy, leaderboard.Around(seasonLeaderboard))
+}
+
+func find(name string) (Season, bool) {
+	for _, season := range Seasons {
+		if season.Name == name {
+			return season, true
+		}
+	}
+
+	return Season{}, false
+}
+
+func seasonsHandler(c *gin.Context) []byte {
+	now := time.Now()
+
+	results := make([]seasonResponse, len(Seasons))
+	for i, season := range Seasons {
+		results[i] = seasonResponse{Season: season, Status: season.Status(now)}
+	}
+
+	json, err := json2.MarshalIndent(results, "", "    ")
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return nil
+	}
+
+	return json
+}
+
+func leaderboardHandler(c *gin.Context) []byte {
+	return seasonLeaderboard(c, 0)
+}
+
+func seasonLeaderboard(c *gin.Context, around int) []byte {
+	var err error
+
+	var request seasonRequest
+	if err = c.ShouldBindUri(&request); err != nil {
+		c.JSON(400, gin.H{"error": err})
+		return nil
+	}
+
+	season, ok := find(request.Season)
+	if !ok || !season.Includes(request.Game) {
+		c.JSON(404, gin.H{"error": "Unknown season or game: " + request.Season + "/" + request.Game})
+		return nil
+	}
+
+	util.Tag(c, util.SeasonTag(season.Name), util.ModeTag(request.Game, request.Mode))
+
+	query := store.LeaderboardQuery{
+		Game:   request.Game,
+		Mode:   request.Mode,
+		User:   request.User,
+		Around: around,
+		From:   timeCode(season.Start),
+		To:     timeCode(season.End),
+	}
+
+	if err = leaderboard.ParseQuery(c, request.Filter, &query); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return nil
+	}
+
+	// A season that has ended but isn't frozen yet is still served from its
+	// events, which give the same result until they are purged.
+	frozen := false
+	if season.Ended(time.Now()) {
+		if frozen, err = Store.Frozen(c, season.Name); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return nil
+		}
+	}
+
+	var result store.LeaderboardResult
+	if frozen {
+		result, err = Store.Standings(c, season.Name, query)
+	} else {
+		result, err = Store.Leaderboard(c, query)
+	}
+
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return nil
+	}
+
+	json, err := json2.MarshalIndent(result, "", "    ")
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return nil
+	}
+
+	return json
+}
@@ -1,37 +1,27 @@
 package statistics
 
 import (
+	"LeaderboardsBackend/store"
 	"LeaderboardsBackend/util"
 	json2 "encoding/json"
-	"fmt"
 	"github.com/gin-gonic/gin"
-	"github.com/simagix/keyhole/mdb"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
-	"log"
+	"time"
 )
 
-var Client *mongo.Client
+var Store store.EventStore
 
-func Register(r *gin.Engine, client *mongo.Client) {
-	Client = client
+func Register(r *gin.Engine, events store.EventStore) {
+	Store = events
 
-	util.CachedGET(r, "/stats/favorite/:time", favoriteHandler)
+	util.CachedGETWithPolicy(r, "/stats/favorite/:time", util.CachePolicy{TTL: time.Hour}, favoriteHandler)
 }
 
 type favoriteRequest struct {
 	Time int64 `uri:"time" binding:""`
 }
 
-type favoriteResult struct {
-	ID    string `bson:"_id"`
-	Count int32  `bson:"count"`
-}
-
 func favoriteHandler(c *gin.Context) []byte {
 	var err error
-	var collection *mongo.Collection
-	var cur *mongo.Cursor
 
 	var request favoriteRequest
 	if err = c.ShouldBindUri(&request); err != nil {
@@ -39,50 +29,14 @@ func favoriteHandler(c *gin.Context) []byte {
 		return nil
 	}
 
-	pipeline := `[
-        { 
-            "$match" : {
-                "time_code" : {
-                    "$gt" : %d
-                }, 
-                "analytic_event_type" : "Finish"
-            }
-        }, 
-        { 
-            "$group" : {
-                "_id" : "$game_mode_id", 
-                "count" : {
-                    "$sum" : 1.0
-                }
-            }
-        }, 
-        { 
-            "$sort" : {
-                "count" : -1.0
-            }
-        }, 
-        { 
-            "$limit" : 1.0
-        }
-    ]`
-	pipeline = fmt.Sprintf(pipeline, request.Time)
+	util.Tag(c, util.AllGamesTag)
 
-	collection = Client.Database("Analytics").Collection("Events")
-	opts := options.Aggregate()
-	opts.SetAllowDiskUse(true)
-	if cur, err = collection.Aggregate(c, mdb.MongoPipeline(pipeline), opts); err != nil {
-		c.JSON(500, gin.H{"error": err})
+	result, err := Store.FavoriteMode(c, request.Time)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
 		return nil
 	}
 
-	var result favoriteResult
-	for cur.Next(c) {
-		err := cur.Decode(&result)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
-
 	json, err := json2.MarshalIndent(result, "", "    ")
 	if err != nil {
 		c.JSON(500, gin.H{"error": err})
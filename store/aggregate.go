@@ -0,0 +1,117 @@
+package store
+
+import (
+	"fmt"
+	"math"
+)
+
+// Aggregations combine a player's Score events for one field into the value
+// they are ranked by. AggregateBest is the highest total scored in a single
+// game instance.
+const (
+	AggregateSum   = "sum"
+	AggregateMax   = "max"
+	AggregateMin   = "min"
+	AggregateAvg   = "avg"
+	AggregateCount = "count"
+	AggregateBest  = "best"
+)
+
+func ValidateAggregation(aggregation string) error {
+	switch aggregation {
+	case AggregateSum, AggregateMax, AggregateMin, AggregateAvg, AggregateCount, AggregateBest:
+		return nil
+	}
+
+	return fmt.Errorf("unknown aggregation %q", aggregation)
+}
+
+// ScoreStats summarises a player's Score events for one field, enough to
+// compute any aggregation without going back to the events.
+type ScoreStats struct {
+	Sum   float64 `bson:"sum"`
+	Count int32   `bson:"count"`
+	Max   float64 `bson:"max"`
+	Min   float64 `bson:"min"`
+	Best  float64 `bson:"best"`
+	// Achieved is the time_code at which the field last changed.
+	Achieved int64 `bson:"achieved"`
+}
+
+// merge combines the stats of two disjoint sets of events.
+func (s ScoreStats) merge(other ScoreStats) ScoreStats {
+	if s.Count == 0 {
+		return other
+	}
+	if other.Count == 0 {
+		return s
+	}
+
+	return ScoreStats{
+		Sum:      s.Sum + other.Sum,
+		Count:    s.Count + other.Count,
+		Max:      math.Max(s.Max, other.Max),
+		Min:      math.Min(s.Min, other.Min),
+		Best:     math.Max(s.Best, other.Best),
+		Achieved: maxInt64(s.Achieved, other.Achieved),
+	}
+}
+
+func (s ScoreStats) value(aggregation string) float64 {
+	switch aggregation {
+	case AggregateMax:
+		return s.Max
+	case AggregateMin:
+		return s.Min
+	case AggregateAvg:
+		if s.Count == 0 {
+			return 0
+		}
+
+		return s.Sum / float64(s.Count)
+	case AggregateCount:
+		return float64(s.Count)
+	case AggregateBest:
+		return s.Best
+	}
+
+	return s.Sum
+}
+
+// aggregateScores fills in each entry's scores from its stats, using the
+// query's aggregation for a field or summing fields without one.
+func aggregateScores(entries []LeaderboardEntry, aggregations map[string]string) []LeaderboardEntry {
+	for i := range entries {
+		entries[i].Scores = make(map[string]float64, len(entries[i].Stats))
+		for field, stats := range entries[i].Stats {
+			entries[i].Scores[field] = stats.value(aggregations[field])
+		}
+	}
+
+	return entries
+}
+
+// add counts one event's value into the stats. Best isn't touched, as it
+// needs the totals of every instance.
+func (s ScoreStats) add(value float64, timeCode int64) ScoreStats {
+	if s.Count == 0 || value > s.Max {
+		s.Max = value
+	}
+	if s.Count == 0 || value < s.Min {
+		s.Min = value
+	}
+
+	s.Sum += value
+	s.Count++
+	s.Achieved = maxInt64(s.Achieved, timeCode)
+
+	return s
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+
+	return b
+}
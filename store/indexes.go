@@ -0,0 +1,203 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Index declares an index that a MongoStore query relies on. Indexes are
+// named after their keys the way the server names them by default, so
+// indexes created before they were declared are recognised.
+type Index struct {
+	Collection string
+	Keys       bson.D
+	Unique     bool
+	Sparse     bool
+	// Purpose names the queries that need the index.
+	Purpose string
+}
+
+func (i Index) Name() string {
+	parts := make([]string, len(i.Keys))
+	for n, key := range i.Keys {
+		parts[n] = fmt.Sprintf("%s_%v", key.Key, key.Value)
+	}
+
+	return strings.Join(parts, "_")
+}
+
+func ascending(fields ...string) bson.D {
+	keys := make(bson.D, len(fields))
+	for i, field := range fields {
+		keys[i] = bson.E{Key: field, Value: 1}
+	}
+
+	return keys
+}
+
+// Indexes are every index MongoStore needs. Events written before ids existed
+// have no event_id, so its unique index is sparse.
+var Indexes = []Index{
+	{Collection: "Events", Keys: ascending("event_id"), Unique: true, Sparse: true, Purpose: "rejecting duplicate events on ingestion"},
+	{Collection: "Events", Keys: ascending("analytic_event_type", "game_id", "game_mode_id", "time_code"), Purpose: "leaderboards, outcomes, score fields, game lists and season freezes"},
+	{Collection: "Events", Keys: ascending("analytic_event_type", "time_code"), Purpose: "recent games and statistics"},
+	{Collection: "Events", Keys: ascending("instance_id"), Purpose: "game instance events"},
+	{Collection: "Events", Keys: ascending("player_uuid"), Purpose: "profiles by player"},
+	{Collection: "Events", Keys: ascending("killer_uuid"), Purpose: "profiles by killer"},
+	{Collection: "Events", Keys: ascending("time_code"), Purpose: "backfills"},
+	{Collection: "SeasonStandings", Keys: ascending("season", "game", "mode"), Purpose: "frozen season standings"},
+	{Collection: "PlayerStats", Keys: ascending("player", "game", "mode"), Purpose: "profiles from player rollups"},
+	{Collection: "GameSummaries", Keys: bson.D{{Key: "game_id", Value: 1}, {Key: "game_mode_id", Value: 1}, {Key: "end_time", Value: -1}}, Purpose: "game lists by game and mode from summaries"},
+	{Collection: "GameSummaries", Keys: bson.D{{Key: "end_time", Value: -1}}, Purpose: "game lists from summaries"},
+	{Collection: "GameSummaries", Keys: bson.D{{Key: "players", Value: 1}, {Key: "end_time", Value: -1}}, Purpose: "recent games from summaries"},
+}
+
+// IndexStatus describes one index, declared or found on the server. Ops
+// counts the times it was used since the server started tracking it at Since.
+type IndexStatus struct {
+	Collection string
+	Name       string
+	Purpose    string
+	Declared   bool
+	Exists     bool
+	Ops        int64
+	Since      time.Time
+}
+
+// Unused reports whether an existing index hasn't served a query since its
+// usage started being tracked.
+func (i IndexStatus) Unused() bool {
+	return i.Exists && i.Ops == 0
+}
+
+func (s *MongoStore) database() *mongo.Database {
+	return s.collection.Database()
+}
+
+// EnsureIndexes creates every declared index that is missing. Creating an
+// index that already exists with the same keys and options does nothing.
+// Indexes are built in the background so the collections stay writable
+// while they build, and one failing doesn't stop the rest from being
+// created; the error lists every index that failed.
+func (s *MongoStore) EnsureIndexes(ctx context.Context) error {
+	var failed []string
+	for _, index := range Indexes {
+		opts := options.Index().SetName(index.Name()).SetBackground(true)
+		if index.Unique {
+			opts.SetUnique(true)
+		}
+		if index.Sparse {
+			opts.SetSparse(true)
+		}
+
+		_, err := s.database().Collection(index.Collection).Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    index.Keys,
+			Options: opts,
+		})
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s.%s: %v", index.Collection, index.Name(), err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to create %d of %d indexes: %s", len(failed), len(Indexes), strings.Join(failed, "; "))
+	}
+
+	return nil
+}
+
+// IndexReport lists the declared indexes and every other index on their
+// collections, with usage from $indexStats. Missing indexes have Exists
+// unset; undeclared ones have Declared unset. The _id index is left out.
+func (s *MongoStore) IndexReport(ctx context.Context) ([]IndexStatus, error) {
+	var collections []string
+	seen := make(map[string]bool)
+	for _, index := range Indexes {
+		if !seen[index.Collection] {
+			seen[index.Collection] = true
+			collections = append(collections, index.Collection)
+		}
+	}
+
+	var report []IndexStatus
+	for _, collection := range collections {
+		used, err := s.indexStats(ctx, collection)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", collection, err)
+		}
+
+		for _, index := range Indexes {
+			if index.Collection != collection {
+				continue
+			}
+
+			status, ok := used[index.Name()]
+			if !ok {
+				status = IndexStatus{Collection: collection, Name: index.Name()}
+			}
+
+			status.Declared = true
+			status.Purpose = index.Purpose
+			report = append(report, status)
+			delete(used, index.Name())
+		}
+
+		var undeclared []IndexStatus
+		for _, status := range used {
+			undeclared = append(undeclared, status)
+		}
+
+		sort.Slice(undeclared, func(i, j int) bool {
+			return undeclared[i].Name < undeclared[j].Name
+		})
+
+		report = append(report, undeclared...)
+	}
+
+	return report, nil
+}
+
+// indexStats returns the indexes of a collection by name, with their usage.
+func (s *MongoStore) indexStats(ctx context.Context, collection string) (map[string]IndexStatus, error) {
+	cur, err := s.database().Collection(collection).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$indexStats", Value: bson.M{}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	found := make(map[string]IndexStatus)
+	for cur.Next(ctx) {
+		var stats struct {
+			Name     string `bson:"name"`
+			Accesses struct {
+				Ops   int64     `bson:"ops"`
+				Since time.Time `bson:"since"`
+			} `bson:"accesses"`
+		}
+		if err := cur.Decode(&stats); err != nil {
+			return nil, err
+		}
+
+		if stats.Name == "_id_" {
+			continue
+		}
+
+		found[stats.Name] = IndexStatus{
+			Collection: collection,
+			Name:       stats.Name,
+			Exists:     true,
+			Ops:        stats.Accesses.Ops,
+			Since:      stats.Accesses.Since,
+		}
+	}
+
+	return found, cur.Err()
+}
@@ -0,0 +1,509 @@
+package store
+
+import (
+	"LeaderboardsBackend/util"
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryStore evaluates the same queries as MongoStore over a slice of events
+// held in memory. It is meant for tests and for running the API offline.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	events    []util.MongoResult
+	ids       map[string]bool
+	standings map[string][]Standing
+	stats     map[string]PlayerStats
+	built     bool
+}
+
+func NewMemoryStore(events []util.MongoResult) *MemoryStore {
+	ids := make(map[string]bool)
+	for _, event := range events {
+		if event.EventID != "" {
+			ids[event.EventID] = true
+		}
+	}
+
+	return &MemoryStore{events: events, ids: ids, standings: make(map[string][]Standing), stats: make(map[string]PlayerStats)}
+}
+
+// LoadMemoryStore reads a JSONL fixture with one event per line, using the
+// same field names as the Events collection.
+func LoadMemoryStore(path string) (*MemoryStore, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var events []util.MongoResult
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var event util.MongoResult
+		if err := json.Unmarshal([]byte(text), &event); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+
+		events = append(events, event)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return NewMemoryStore(events), nil
+}
+
+func (s *MemoryStore) filter(keep func(event util.MongoResult) bool) []util.MongoResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var results []util.MongoResult
+	for _, event := range s.events {
+		if keep(event) {
+			results = append(results, event)
+		}
+	}
+
+	return results
+}
+
+func (s *MemoryStore) Games(ctx context.Context) ([]GameModes, error) {
+	modes := make(map[string][]string)
+	for _, event := range s.filter(func(event util.MongoResult) bool { return event.GameID != "" }) {
+		if !contains(modes[event.GameID], event.GameModeID) {
+			modes[event.GameID] = append(modes[event.GameID], event.GameModeID)
+		}
+	}
+
+	var results []GameModes
+	for game, gameModes := range modes {
+		results = append(results, GameModes{Game: game, GameModes: gameModes})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Game.(string) < results[j].Game.(string)
+	})
+
+	return results, nil
+}
+
+func (s *MemoryStore) GameList(ctx context.Context, query GameListQuery) ([]GameSummary, error) {
+	finishes := s.filter(func(event util.MongoResult) bool {
+		if event.AnalyticEventType != "Finish" {
+			return false
+		}
+
+		if query.Game != "" {
+			if event.GameID != query.Game {
+				return false
+			}
+
+			if query.Mode != "" && event.GameModeID != query.Mode {
+				return false
+			}
+		}
+
+		return true
+	})
+
+	return paginate(summarise(finishes), query.Page, query.Length), nil
+}
+
+func (s *MemoryStore) RecentGames(ctx context.Context, query RecentQuery) ([]GameSummary, error) {
+	finishes := s.filter(func(event util.MongoResult) bool {
+		if event.AnalyticEventType != "Finish" {
+			return false
+		}
+
+		_, won := event.Winners[query.Player]
+		_, lost := event.Losers[query.Player]
+
+		return won || lost
+	})
+
+	return paginate(summarise(finishes), query.Page, query.Length), nil
+}
+
+func (s *MemoryStore) InstanceEvents(ctx context.Context, id string) ([]util.MongoResult, error) {
+	return s.filter(func(event util.MongoResult) bool {
+		return event.InstanceID == id
+	}), nil
+}
+
+func (s *MemoryStore) PlayerScores(ctx context.Context, query LeaderboardQuery) ([]LeaderboardEntry, error) {
+	scores := s.filter(func(event util.MongoResult) bool {
+		return event.AnalyticEventType == "Score" && onBoard(event, query)
+	})
+
+	entries := sumScores(scores)
+	if query.Outcomes {
+		results := s.filter(func(event util.MongoResult) bool {
+			return (event.AnalyticEventType == "Death" || event.AnalyticEventType == "Finish") && onBoard(event, query)
+		})
+
+		entries = mergeOutcomes(entries, collectOutcomes(results))
+	}
+
+	return scoreEntries(onlyPlayers(entries, query.Players), query), nil
+}
+
+// onBoard reports whether an event falls within a leaderboard query's game,
+// mode, instance and time window.
+func onBoard(event util.MongoResult, query LeaderboardQuery) bool {
+	return (query.Instance == "" || event.InstanceID == query.Instance) &&
+		(query.Mode == "" || event.GameModeID == query.Mode) &&
+		(query.Game == "" || event.GameID == query.Game) &&
+		(query.From == 0 || event.TimeCode >= query.From) &&
+		(query.To == 0 || event.TimeCode < query.To)
+}
+
+func (s *MemoryStore) Leaderboard(ctx context.Context, query LeaderboardQuery) (LeaderboardResult, error) {
+	entries, err := s.PlayerScores(ctx, query)
+	if err != nil {
+		return LeaderboardResult{}, err
+	}
+
+	return rankEntries(entries, query), nil
+}
+
+func (s *MemoryStore) ProfileEvents(ctx context.Context, query ProfileQuery) ([]util.MongoResult, error) {
+	return s.filter(func(event util.MongoResult) bool {
+		return (event.PlayerUUID == query.Player || event.KillerUUID == query.Player) &&
+			(query.Game == "" || event.GameID == query.Game) &&
+			(query.Mode == "" || event.GameModeID == query.Mode)
+	}), nil
+}
+
+func (s *MemoryStore) ScoreFields(ctx context.Context, game, mode string) ([]string, error) {
+	var fields []string
+	for _, event := range s.filter(func(event util.MongoResult) bool {
+		return event.AnalyticEventType == "Score" && event.GameID == game && event.GameModeID == mode
+	}) {
+		if event.ScoreField != "" && !contains(fields, event.ScoreField) {
+			fields = append(fields, event.ScoreField)
+		}
+	}
+
+	sort.Strings(fields)
+
+	return fields, nil
+}
+
+func (s *MemoryStore) FavoriteMode(ctx context.Context, since int64) (FavoriteMode, error) {
+	counts := make(map[string]int32)
+	for _, event := range s.filter(func(event util.MongoResult) bool {
+		return event.TimeCode > since && event.AnalyticEventType == "Finish"
+	}) {
+		counts[event.GameModeID]++
+	}
+
+	var result FavoriteMode
+	for mode, count := range counts {
+		if count > result.Count || (count == result.Count && mode < result.ID) {
+			result = FavoriteMode{ID: mode, Count: count}
+		}
+	}
+
+	return result, nil
+}
+
+func (s *MemoryStore) InsertEvents(ctx context.Context, events []util.MongoResult) (InsertResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result InsertResult
+	for _, event := range events {
+		if s.ids[event.EventID] {
+			result.Skipped++
+			continue
+		}
+
+		s.ids[event.EventID] = true
+		s.events = append(s.events, event)
+		result.Inserted = append(result.Inserted, event)
+	}
+
+	return result, nil
+}
+
+func (s *MemoryStore) SaveStandings(ctx context.Context, season string, standings []Standing) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	saved := make([]Standing, len(standings))
+	for i, standing := range standings {
+		standing.Season = season
+		saved[i] = standing
+	}
+
+	s.standings[season] = saved
+
+	return nil
+}
+
+func (s *MemoryStore) Frozen(ctx context.Context, season string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.standings[season]
+
+	return ok, nil
+}
+
+func (s *MemoryStore) Standings(ctx context.Context, season string, query LeaderboardQuery) (LeaderboardResult, error) {
+	s.mu.RLock()
+	standings := s.standings[season]
+	s.mu.RUnlock()
+
+	var matched []Standing
+	for _, standing := range standings {
+		if matchesStanding(standing, query) {
+			matched = append(matched, standing)
+		}
+	}
+
+	return rankEntries(scoreEntries(mergeStandings(matched), query), query), nil
+}
+
+func (s *MemoryStore) UpdatePlayerStats(ctx context.Context, events []util.MongoResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	touched := make(map[string]bool)
+	players := make(map[string]bool)
+	for _, rollup := range rollupStats(events) {
+		touched[rollup.ID] = true
+		players[rollup.Player] = true
+	}
+
+	var involved []util.MongoResult
+	for _, event := range s.events {
+		if players[event.PlayerUUID] || players[event.KillerUUID] {
+			involved = append(involved, event)
+		}
+	}
+
+	for id := range touched {
+		delete(s.stats, id)
+	}
+
+	for _, rollup := range rollupStats(involved) {
+		if touched[rollup.ID] {
+			s.stats[rollup.ID] = rollup
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) RebuildPlayerStats(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats = make(map[string]PlayerStats)
+	for _, rollup := range rollupStats(s.events) {
+		s.stats[rollup.ID] = rollup
+	}
+	s.built = true
+
+	return nil
+}
+
+func (s *MemoryStore) StatsBuilt(ctx context.Context) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.built, nil
+}
+
+// The memory store answers game lists from its events, which are always
+// current, so its summaries need no upkeep.
+func (s *MemoryStore) UpdateGameSummaries(ctx context.Context, events []util.MongoResult) error {
+	return nil
+}
+
+func (s *MemoryStore) RebuildGameSummaries(ctx context.Context) error {
+	return nil
+}
+
+func (s *MemoryStore) SummariesBuilt(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+func (s *MemoryStore) SummaryGameList(ctx context.Context, query GameListQuery) ([]GameSummary, error) {
+	return s.GameList(ctx, query)
+}
+
+func (s *MemoryStore) SummaryRecentGames(ctx context.Context, query RecentQuery) ([]GameSummary, error) {
+	return s.RecentGames(ctx, query)
+}
+
+func (s *MemoryStore) PlayerStats(ctx context.Context, query ProfileQuery) ([]PlayerStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var results []PlayerStats
+	for _, stats := range s.stats {
+		if !matchesStats(stats, query) {
+			continue
+		}
+
+		totals := make(map[string]int64, len(stats.Totals))
+		for total, amount := range stats.Totals {
+			totals[total] = amount
+		}
+		stats.Totals = totals
+
+		results = append(results, stats)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].ID < results[j].ID
+	})
+
+	return results, nil
+}
+
+// finishEvents keeps the Finish events of a batch.
+func finishEvents(events []util.MongoResult) []util.MongoResult {
+	var kept []util.MongoResult
+	for _, event := range events {
+		if event.AnalyticEventType == "Finish" {
+			kept = append(kept, event)
+		}
+	}
+
+	return kept
+}
+
+// summarise collapses Finish events into distinct games, newest first.
+func summarise(finishes []util.MongoResult) []GameSummary {
+	seen := make(map[string]bool)
+
+	var results []GameSummary
+	for _, event := range finishes {
+		summary := GameSummary{
+			GameID:     event.GameID,
+			GameModeID: event.GameModeID,
+			InstanceID: event.InstanceID,
+			Winners:    event.Winners,
+			Losers:     event.Losers,
+			EndTime:    event.TimeCode,
+		}
+
+		key := fmt.Sprint(summary)
+		if seen[key] {
+			continue
+		}
+
+		seen[key] = true
+		results = append(results, summary)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].EndTime > results[j].EndTime
+	})
+
+	return results
+}
+
+func paginate(results []GameSummary, page, length int) []GameSummary {
+	start, end := bounds(len(results), page, length)
+
+	return results[start:end]
+}
+
+// bounds clamps a page to the [start, end) range of a slice of size n.
+func bounds(n, page, length int) (int, int) {
+	start := Offset(page, length)
+	if start < 0 {
+		start = 0
+	}
+	if start > n {
+		start = n
+	}
+
+	end := start + length
+	if length < 0 || end > n {
+		end = n
+	}
+
+	return start, end
+}
+
+// sumScores collects each player's ScoreStats per score field, in the order
+// players first appear.
+func sumScores(events []util.MongoResult) []LeaderboardEntry {
+	index := make(map[[2]string]int)
+
+	var entries []LeaderboardEntry
+	var instances []map[string]map[string]float64
+	for _, event := range events {
+		key := [2]string{event.PlayerUUID, event.PlayerName}
+
+		i, ok := index[key]
+		if !ok {
+			i = len(entries)
+			index[key] = i
+			entries = append(entries, LeaderboardEntry{
+				ID:    event.PlayerUUID,
+				Name:  event.PlayerName,
+				Stats: make(map[string]ScoreStats),
+			})
+			instances = append(instances, make(map[string]map[string]float64))
+		}
+
+		field := event.ScoreField
+		entries[i].Stats[field] = entries[i].Stats[field].add(float64(event.Value), event.TimeCode)
+
+		if instances[i][field] == nil {
+			instances[i][field] = make(map[string]float64)
+		}
+		instances[i][field][event.InstanceID] += float64(event.Value)
+	}
+
+	for i, entry := range entries {
+		for field, totals := range instances[i] {
+			stats := entry.Stats[field]
+
+			first := true
+			for _, total := range totals {
+				if first || total > stats.Best {
+					stats.Best = total
+					first = false
+				}
+			}
+
+			entry.Stats[field] = stats
+		}
+	}
+
+	return entries
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,299 @@
+package store
+
+import (
+	"LeaderboardsBackend/util"
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func score(instance, player, field string, value int32, timeCode int64) util.MongoResult {
+	return util.MongoResult{
+		TimeCode:          timeCode,
+		ServerEventType:   "Game",
+		GameID:            "arena",
+		GameModeID:        "solo",
+		InstanceID:        instance,
+		AnalyticEventType: "Score",
+		ScoreField:        field,
+		Value:             value,
+		PlayerName:        player,
+		PlayerUUID:        player,
+	}
+}
+
+func finish(instance, mode, winner, loser string, timeCode int64) util.MongoResult {
+	return util.MongoResult{
+		TimeCode:          timeCode,
+		ServerEventType:   "Game",
+		GameID:            "arena",
+		GameModeID:        mode,
+		InstanceID:        instance,
+		AnalyticEventType: "Finish",
+		Winners:           map[string]string{winner: winner},
+		Losers:            map[string]string{loser: loser},
+	}
+}
+
+// ranked lists the ids and positions of a result, in order.
+func ranked(result LeaderboardResult) ([]string, []int32) {
+	var ids []string
+	var positions []int32
+	for _, entry := range result.Entries {
+		ids = append(ids, entry.ID)
+		positions = append(positions, entry.Position)
+	}
+
+	return ids, positions
+}
+
+// scores lists each entry's score in field, by uuid.
+func scores(result LeaderboardResult, field string) map[string]float64 {
+	found := make(map[string]float64)
+	for _, entry := range result.Entries {
+		found[entry.ID] = entry.Scores[field]
+	}
+
+	return found
+}
+
+// instances lists the instance ids of game summaries, in order.
+func instances(games []GameSummary) []string {
+	var ids []string
+	for _, game := range games {
+		ids = append(ids, game.InstanceID)
+	}
+
+	return ids
+}
+
+func TestLoadMemoryStore(t *testing.T) {
+	file, err := ioutil.TempFile("", "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	file.WriteString(`{"time_code": 1000, "game_id": "arena", "game_mode_id": "solo", "instance_id": "1", "analytic_event_type": "Score", "score_field": "kills", "value": 3, "player_uuid": "alice", "player_name": "alice"}
+
+{"time_code": 2000, "game_id": "arena", "game_mode_id": "solo", "instance_id": "1", "analytic_event_type": "Finish", "winners": {"alice": "alice"}}
+`)
+	file.Close()
+
+	events, err := LoadMemoryStore(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := events.InstanceEvents(context.Background(), "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(found) != 2 || found[0].Value != 3 || found[1].Winners["alice"] != "alice" {
+		t.Errorf("got %+v, want the score and finish events", found)
+	}
+}
+
+func TestLoadMemoryStoreErrors(t *testing.T) {
+	file, err := ioutil.TempFile("", "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	file.WriteString("{\"time_code\": 1}\nnot json\n")
+	file.Close()
+
+	if _, err := LoadMemoryStore(file.Name()); err == nil {
+		t.Error("expected an error for a malformed line")
+	}
+}
+
+func TestMemoryLeaderboard(t *testing.T) {
+	events := NewMemoryStore([]util.MongoResult{
+		score("1", "alice", "kills", 3, 1000),
+		score("1", "bob", "kills", 4, 1000),
+		score("2", "alice", "kills", 2, 2000),
+		score("2", "carol", "wins", 1, 2000),
+	})
+
+	result, err := events.Leaderboard(context.Background(), LeaderboardQuery{
+		Game:    "arena",
+		Mode:    "solo",
+		Filters: []string{"kills"},
+		Page:    1,
+		Length:  100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids, positions := ranked(result)
+	if want := []string{"alice", "bob"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got %v, want %v", ids, want)
+	}
+	if want := []int32{0, 1}; !reflect.DeepEqual(positions, want) {
+		t.Errorf("got positions %v, want %v", positions, want)
+	}
+	if want := map[string]float64{"alice": 5, "bob": 4}; !reflect.DeepEqual(scores(result, "kills"), want) {
+		t.Errorf("got scores %v, want %v", scores(result, "kills"), want)
+	}
+}
+
+func TestMemoryLeaderboardInstance(t *testing.T) {
+	events := NewMemoryStore([]util.MongoResult{
+		score("1", "alice", "kills", 3, 1000),
+		score("2", "alice", "kills", 2, 2000),
+		score("2", "bob", "kills", 5, 2000),
+	})
+
+	result, err := events.Leaderboard(context.Background(), LeaderboardQuery{
+		Instance: "2",
+		Filters:  []string{"kills"},
+		Page:     1,
+		Length:   100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := map[string]float64{"alice": 2, "bob": 5}; !reflect.DeepEqual(scores(result, "kills"), want) {
+		t.Errorf("got %v, want %v", scores(result, "kills"), want)
+	}
+}
+
+func TestMemoryGameList(t *testing.T) {
+	events := NewMemoryStore([]util.MongoResult{
+		finish("1", "solo", "alice", "bob", 1000),
+		finish("2", "duo", "bob", "carol", 3000),
+		finish("3", "solo", "carol", "alice", 2000),
+		score("3", "alice", "kills", 1, 2000),
+	})
+
+	tests := []struct {
+		query GameListQuery
+		want  []string
+	}{
+		{GameListQuery{Page: 1, Length: 100}, []string{"2", "3", "1"}},
+		{GameListQuery{Game: "arena", Mode: "solo", Page: 1, Length: 100}, []string{"3", "1"}},
+		{GameListQuery{Game: "other", Page: 1, Length: 100}, nil},
+		{GameListQuery{Page: 2, Length: 2}, []string{"1"}},
+	}
+
+	for _, test := range tests {
+		games, err := events.GameList(context.Background(), test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := instances(games); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%+v: got %v, want %v", test.query, got, test.want)
+		}
+	}
+}
+
+func TestMemoryRecentGames(t *testing.T) {
+	events := NewMemoryStore([]util.MongoResult{
+		finish("1", "solo", "alice", "bob", 1000),
+		finish("2", "duo", "bob", "carol", 3000),
+		finish("3", "solo", "carol", "alice", 2000),
+	})
+
+	games, err := events.RecentGames(context.Background(), RecentQuery{Player: "alice", Page: 1, Length: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"3", "1"}; !reflect.DeepEqual(instances(games), want) {
+		t.Errorf("got %v, want %v", instances(games), want)
+	}
+}
+
+func TestMemoryLeaderboardWindow(t *testing.T) {
+	events := NewMemoryStore([]util.MongoResult{
+		score("1", "alice", "kills", 1, 999),
+		score("2", "alice", "kills", 2, 1000),
+		score("3", "alice", "kills", 4, 1999),
+		score("4", "alice", "kills", 8, 2000),
+	})
+
+	tests := []struct {
+		from, to int64
+		want     float64
+	}{
+		{0, 0, 15},
+		{1000, 0, 14},
+		{0, 2000, 7},
+		{1000, 2000, 6},
+	}
+
+	for _, test := range tests {
+		result, err := events.Leaderboard(context.Background(), LeaderboardQuery{
+			Filters: []string{"kills"},
+			Page:    1,
+			Length:  100,
+			From:    test.from,
+			To:      test.to,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := scores(result, "kills")["alice"]; got != test.want {
+			t.Errorf("[%d, %d): got %g, want %g", test.from, test.to, got, test.want)
+		}
+	}
+
+	result, err := events.Leaderboard(context.Background(), LeaderboardQuery{
+		Filters: []string{"kills"},
+		Page:    1,
+		Length:  100,
+		From:    3000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Entries) != 0 || result.TotalCount != 0 {
+		t.Errorf("empty window: got %d entries of %d", len(result.Entries), result.TotalCount)
+	}
+}
+
+func TestMemoryLeaderboardAggregations(t *testing.T) {
+	events := NewMemoryStore([]util.MongoResult{
+		score("1", "alice", "kills", 3, 1000),
+		score("1", "alice", "kills", 1, 1100),
+		score("2", "alice", "kills", 2, 2000),
+	})
+
+	tests := []struct {
+		aggregation string
+		want        float64
+	}{
+		{AggregateSum, 6},
+		{AggregateMax, 3},
+		{AggregateMin, 1},
+		{AggregateAvg, 2},
+		{AggregateCount, 3},
+		{AggregateBest, 4},
+	}
+
+	for _, test := range tests {
+		result, err := events.Leaderboard(context.Background(), LeaderboardQuery{
+			Filters:      []string{"kills"},
+			Aggregations: map[string]string{"kills": test.aggregation},
+			Page:         1,
+			Length:       100,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := scores(result, "kills")["alice"]; got != test.want {
+			t.Errorf("%s: got %g, want %g", test.aggregation, got, test.want)
+		}
+	}
+}
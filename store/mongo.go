@@ -0,0 +1,1427 @@
+package store
+
+import (
+	"LeaderboardsBackend/pipeline"
+	"LeaderboardsBackend/util"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MongoStore answers queries by running aggregation pipelines over the
+// Analytics.Events collection. Frozen season standings live in
+// Analytics.SeasonStandings, with one marker per frozen season in
+// Analytics.Seasons. Player rollups live in Analytics.PlayerStats and game
+// summaries in Analytics.GameSummaries; Analytics.Rollups marks when they were
+// last rebuilt.
+type MongoStore struct {
+	collection *mongo.Collection
+	standings  *mongo.Collection
+	seasons    *mongo.Collection
+	stats      *mongo.Collection
+	summaries  *mongo.Collection
+	rollups    *mongo.Collection
+}
+
+func NewMongoStore(client *mongo.Client) *MongoStore {
+	database := client.Database("Analytics")
+
+	return &MongoStore{
+		collection: database.Collection("Events"),
+		standings:  database.Collection("SeasonStandings"),
+		seasons:    database.Collection("Seasons"),
+		stats:      database.Collection("PlayerStats"),
+		summaries:  database.Collection("GameSummaries"),
+		rollups:    database.Collection("Rollups"),
+	}
+}
+
+// eventFields is the projection used when handing raw events back to callers.
+var eventFields = pipeline.Include(
+	"time_code", "server_event_type", "game_id", "game_mode_id", "instance_id",
+	"world_name", "analytic_event_type", "from", "to", "value", "score_field",
+	"player_name", "player_uuid", "death_event_type", "death_details",
+	"killer_name", "killer_uuid", "winners", "losers", "finish_event_type",
+	"author", "phrase", "tags", "time_since_start",
+)
+
+// gameSummaryID groups Finish events into one document per game.
+var gameSummaryID = bson.M{
+	"game_id":      "$game_id",
+	"game_mode_id": "$game_mode_id",
+	"instance_id":  "$instance_id",
+	"winners":      "$winners",
+	"losers":       "$losers",
+	"end_time":     "$time_code",
+}
+
+// timeCodeRange matches time codes in [from, to), or returns nil when both
+// bounds are open.
+func timeCodeRange(from, to int64) bson.M {
+	if from == 0 && to == 0 {
+		return nil
+	}
+
+	window := bson.M{}
+	if from != 0 {
+		window["$gte"] = from
+	}
+	if to != 0 {
+		window["$lt"] = to
+	}
+
+	return window
+}
+
+func (s *MongoStore) aggregate(ctx context.Context, builder *pipeline.Builder) (*mongo.Cursor, error) {
+	opts := options.Aggregate()
+	opts.SetAllowDiskUse(true)
+
+	return s.collection.Aggregate(ctx, builder.Pipeline(), opts)
+}
+
+func (s *MongoStore) events(ctx context.Context, builder *pipeline.Builder) ([]util.MongoResult, error) {
+	cur, err := s.aggregate(ctx, builder)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var results []util.MongoResult
+	for cur.Next(ctx) {
+		var result util.MongoResult
+		if err := cur.Decode(&result); err != nil {
+			return nil, err
+		}
+
+		results = append(results, result)
+	}
+
+	return results, cur.Err()
+}
+
+func (s *MongoStore) gameSummaries(ctx context.Context, builder *pipeline.Builder) ([]GameSummary, error) {
+	cur, err := s.aggregate(ctx, builder)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var results []GameSummary
+	for cur.Next(ctx) {
+		var result GameSummary
+		if err := cur.Decode(&result); err != nil {
+			return nil, err
+		}
+
+		results = append(results, result)
+	}
+
+	return results, cur.Err()
+}
+
+func (s *MongoStore) Games(ctx context.Context) ([]GameModes, error) {
+	builder := pipeline.New().
+		Group("$game_id", bson.M{"gamemodes": bson.M{"$addToSet": "$game_mode_id"}})
+
+	cur, err := s.aggregate(ctx, builder)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var results []GameModes
+	for cur.Next(ctx) {
+		var result GameModes
+		if err := cur.Decode(&result); err != nil {
+			return nil, err
+		}
+
+		if result.Game != nil {
+			results = append(results, result)
+		}
+	}
+
+	return results, cur.Err()
+}
+
+func (s *MongoStore) GameList(ctx context.Context, query GameListQuery) ([]GameSummary, error) {
+	match := bson.M{"analytic_event_type": "Finish"}
+	if query.Game != "" {
+		match["game_id"] = query.Game
+
+		if query.Mode != "" {
+			match["game_mode_id"] = query.Mode
+		}
+	}
+
+	builder := pipeline.New().
+		Match(match).
+		Group(gameSummaryID, nil).
+		ReplaceRoot("$_id").
+		Sort(bson.D{pipeline.Order("end_time", false)}).
+		Skip(Offset(query.Page, query.Length)).
+		Limit(query.Length)
+
+	return s.gameSummaries(ctx, builder)
+}
+
+func (s *MongoStore) RecentGames(ctx context.Context, query RecentQuery) ([]GameSummary, error) {
+	// Inside $expr a string starting with "$" would be read as a field path.
+	player := bson.M{"$literal": query.Player}
+	played := bson.M{"$or": bson.A{
+		bson.M{"$in": bson.A{player, pipeline.Keys("winners")}},
+		bson.M{"$in": bson.A{player, pipeline.Keys("losers")}},
+	}}
+
+	builder := pipeline.New().
+		Match(bson.M{"analytic_event_type": "Finish", "$expr": played}).
+		Group(gameSummaryID, nil).
+		ReplaceRoot("$_id").
+		Sort(bson.D{pipeline.Order("end_time", false)}).
+		Skip(Offset(query.Page, query.Length)).
+		Limit(query.Length)
+
+	return s.gameSummaries(ctx, builder)
+}
+
+func (s *MongoStore) InstanceEvents(ctx context.Context, id string) ([]util.MongoResult, error) {
+	builder := pipeline.New().
+		Match(bson.M{"instance_id": id}).
+		Project(eventFields)
+
+	return s.events(ctx, builder)
+}
+
+// boardMatch matches the events within a leaderboard query's game, mode,
+// instance and time window.
+func boardMatch(query LeaderboardQuery) bson.M {
+	match := bson.M{}
+	if query.Instance != "" {
+		match["instance_id"] = query.Instance
+	}
+	if query.Mode != "" {
+		match["game_mode_id"] = query.Mode
+	}
+	if query.Game != "" {
+		match["game_id"] = query.Game
+	}
+	if window := timeCodeRange(query.From, query.To); window != nil {
+		match["time_code"] = window
+	}
+
+	return match
+}
+
+// scoreFields projects what playerScores needs of a Score event. Events
+// written with their value left out count as 0 rather than null, which
+// $max and $min would pass on and ScoreStats can't decode.
+var scoreFields = bson.M{
+	"value":       bson.M{"$ifNull": bson.A{"$value", 0}},
+	"score_field": 1,
+	"player_uuid": 1,
+	"player_name": 1,
+	"instance_id": 1,
+	"time_code":   1,
+}
+
+// playerScores collects the Score events matched by a leaderboard query into
+// one document per player, with a score field -> ScoreStats map. Events are
+// first totalled per game instance so the best single game can be found.
+func playerScores(query LeaderboardQuery) *pipeline.Builder {
+	match := boardMatch(query)
+	match["analytic_event_type"] = "Score"
+	if len(query.Players) > 0 {
+		match["player_uuid"] = bson.M{"$in": query.Players}
+	}
+
+	player := bson.M{"uuid": "$_id.uuid", "name": "$_id.name"}
+	field := bson.M{"uuid": "$_id.uuid", "name": "$_id.name", "score": "$_id.score"}
+
+	builder := pipeline.New().
+		Match(match).
+		Project(scoreFields).
+		Group(bson.M{"uuid": "$player_uuid", "name": "$player_name", "score": "$score_field", "instance": "$instance_id"}, bson.M{
+			"sum":      bson.M{"$sum": "$value"},
+			"count":    bson.M{"$sum": 1},
+			"max":      bson.M{"$max": "$value"},
+			"min":      bson.M{"$min": "$value"},
+			"achieved": bson.M{"$max": "$time_code"},
+		}).
+		Group(field, bson.M{
+			"sum":      bson.M{"$sum": "$sum"},
+			"count":    bson.M{"$sum": "$count"},
+			"max":      bson.M{"$max": "$max"},
+			"min":      bson.M{"$min": "$min"},
+			"best":     bson.M{"$max": "$sum"},
+			"achieved": bson.M{"$max": "$achieved"},
+		}).
+		Group(player, bson.M{
+			"stats": bson.M{"$push": bson.M{
+				"k": "$_id.score",
+				"v": bson.M{
+					"sum":      "$sum",
+					"count":    "$count",
+					"max":      "$max",
+					"min":      "$min",
+					"best":     "$best",
+					"achieved": "$achieved",
+				},
+			}},
+		}).
+		Project(bson.M{
+			"uuid":  "$_id.uuid",
+			"name":  "$_id.name",
+			"stats": bson.M{"$arrayToObject": "$stats"},
+		})
+
+	return builder
+}
+
+func (s *MongoStore) PlayerScores(ctx context.Context, query LeaderboardQuery) ([]LeaderboardEntry, error) {
+	cur, err := s.aggregate(ctx, playerScores(query))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var results []LeaderboardEntry
+	for cur.Next(ctx) {
+		var result LeaderboardEntry
+		if err := cur.Decode(&result); err != nil {
+			return nil, err
+		}
+
+		results = append(results, result)
+	}
+
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	if query.Outcomes {
+		outcomes, err := s.playerOutcomes(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+
+		results = onlyPlayers(mergeOutcomes(results, outcomes), query.Players)
+	}
+
+	return scoreEntries(results, query), nil
+}
+
+// creditedKillerExpr is creditedKiller as an aggregation expression, missing
+// when a player killed themselves.
+var creditedKillerExpr = bson.M{"$cond": bson.A{
+	bson.M{"$ne": bson.A{"$killer_uuid", "$player_uuid"}},
+	"$killer_uuid",
+	"$$REMOVE",
+}}
+
+// playerOutcomes counts kills and deaths from Death events and wins and games
+// from the winners and losers of Finish events, by turning every event into
+// one row per player involved.
+func (s *MongoStore) playerOutcomes(ctx context.Context, query LeaderboardQuery) ([]playerOutcomes, error) {
+	match := boardMatch(query)
+	match["analytic_event_type"] = bson.M{"$in": bson.A{"Death", "Finish"}}
+
+	players := func(field string, row bson.M) bson.M {
+		row["uuid"] = "$$player.k"
+		row["name"] = "$$player.v"
+
+		return bson.M{"$map": bson.M{
+			"input": bson.M{"$objectToArray": bson.M{"$ifNull": bson.A{"$" + field, bson.M{}}}},
+			"as":    "player",
+			"in":    row,
+		}}
+	}
+
+	rows := bson.M{"$concatArrays": bson.A{
+		bson.M{"$cond": bson.A{
+			bson.M{"$eq": bson.A{"$analytic_event_type", "Death"}},
+			bson.A{
+				bson.M{"uuid": "$player_uuid", "name": "$player_name", "deaths": bson.M{"$literal": 1}},
+				bson.M{"uuid": creditedKillerExpr, "name": "$killer_name", "kills": bson.M{"$literal": 1}},
+			},
+			bson.A{},
+		}},
+		players("winners", bson.M{"win": "$instance_id", "game": "$instance_id"}),
+		players("losers", bson.M{"game": "$instance_id"}),
+	}}
+
+	distinct := func(field string) bson.M {
+		return bson.M{"$size": bson.M{"$setDifference": bson.A{"$" + field, bson.A{nil}}}}
+	}
+
+	builder := pipeline.New().
+		Match(match).
+		Project(bson.M{"rows": rows}).
+		Unwind("$rows", "").
+		ReplaceRoot("$rows").
+		Match(bson.M{"uuid": bson.M{"$nin": bson.A{nil, ""}}}).
+		Group("$uuid", bson.M{
+			"name":   bson.M{"$last": "$name"},
+			"kills":  bson.M{"$sum": "$kills"},
+			"deaths": bson.M{"$sum": "$deaths"},
+			"wins":   bson.M{"$addToSet": bson.M{"$ifNull": bson.A{"$win", nil}}},
+			"games":  bson.M{"$addToSet": bson.M{"$ifNull": bson.A{"$game", nil}}},
+		}).
+		Project(bson.M{
+			"name":   1,
+			"kills":  1,
+			"deaths": 1,
+			"wins":   distinct("wins"),
+			"games":  distinct("games"),
+		})
+
+	cur, err := s.aggregate(ctx, builder)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var results []playerOutcomes
+	for cur.Next(ctx) {
+		var result playerOutcomes
+		if err := cur.Decode(&result); err != nil {
+			return nil, err
+		}
+
+		results = append(results, result)
+	}
+
+	return results, cur.Err()
+}
+
+// Leaderboard ranks boards built from score fields alone inside the pipeline,
+// so only the requested page leaves Mongo. Boards with metrics or outcomes
+// need values computed in Go, so their entries are streamed out and ranked
+// with rankEntries, as they are whenever the server rejects the ranking
+// pipeline, e.g. before MongoDB 5.0, which lacks $setWindowFields.
+func (s *MongoStore) Leaderboard(ctx context.Context, query LeaderboardQuery) (LeaderboardResult, error) {
+	for _, filter := range query.Filters {
+		if field := strings.TrimPrefix(filter, "-"); !pipeline.ValidField(field) {
+			return LeaderboardResult{}, fmt.Errorf("invalid score field %q", field)
+		}
+	}
+
+	if len(query.Metrics) == 0 && !query.Outcomes {
+		result, err := s.rankedLeaderboard(ctx, query)
+		if _, rejected := err.(mongo.CommandError); !rejected {
+			return result, err
+		}
+	}
+
+	entries, err := s.PlayerScores(ctx, query)
+	if err != nil {
+		return LeaderboardResult{}, err
+	}
+
+	return rankEntries(entries, query), nil
+}
+
+// rankedBoard extends playerScores with what rankEntries does in Go: it drops
+// players without the first filter, orders the rest by the filters and then
+// the tiebreak, and records each player's 1-based place in that order as
+// index and their zero-based position under the query's ranking.
+//
+// $rank, $denseRank and $documentNumber only accept a single sortBy key, so
+// the order is ranked one key at a time: each filter is ranked among players
+// tied on the filters before it, and the competition rank adds up how many
+// players each of those rankings puts above. The tiebreak is folded into one
+// string that sorts the same way, and numbers players within a tie.
+func rankedBoard(query LeaderboardQuery) *pipeline.Builder {
+	builder := playerScores(query)
+
+	var first string
+	if len(query.Filters) > 0 {
+		first = strings.TrimPrefix(query.Filters[0], "-")
+		builder.Match(bson.M{"stats." + first: bson.M{"$exists": true}})
+	}
+
+	// Missing fields rank as 0, like a missing key in Scores does.
+	keys := bson.M{"tiebreak": tiebreakExpr(query.Tiebreak, first)}
+	for i, filter := range query.Filters {
+		field := strings.TrimPrefix(filter, "-")
+		keys["score"+strconv.Itoa(i)] = bson.M{"$ifNull": bson.A{aggregateExpr(field, query.Aggregations[field]), 0}}
+	}
+	builder.Stage("$addFields", keys)
+
+	// tied holds the scores ranked so far; rank starts at 1 and adds the
+	// players each filter puts above among those tied on the filters before.
+	var tied bson.D
+	rank := bson.A{1}
+	for i, filter := range query.Filters {
+		key := "score" + strconv.Itoa(i)
+		above := "above" + strconv.Itoa(i)
+
+		builder.Stage("$setWindowFields", window(tied, pipeline.Order(key, strings.HasPrefix(filter, "-")), above, "$rank"))
+
+		tied = append(tied, bson.E{Key: key, Value: "$" + key})
+		rank = append(rank, bson.M{"$subtract": bson.A{"$" + above, 1}})
+	}
+
+	builder.Stage("$setWindowFields", window(tied, pipeline.Order("tiebreak", true), "within", "$documentNumber"))
+	builder.Stage("$addFields", bson.M{"rank": bson.M{"$add": rank}})
+	builder.Stage("$addFields", bson.M{"index": bson.M{"$add": bson.A{"$rank", "$within", -1}}})
+
+	position := "$index"
+	switch query.Ranking {
+	case RankCompetition:
+		position = "$rank"
+	case RankDense:
+		// Competition ranks are equal exactly when the scores are.
+		builder.Stage("$setWindowFields", window(nil, pipeline.Order("rank", true), "dense", "$denseRank"))
+		position = "$dense"
+	}
+
+	return builder.Stage("$addFields", bson.M{"position": bson.M{"$subtract": bson.A{position, 1}}})
+}
+
+// window is a $setWindowFields stage numbering players within each group
+// tied on partition, in the order of a single sort key.
+func window(partition bson.D, sortBy bson.E, output, function string) bson.M {
+	stage := bson.M{
+		"sortBy": bson.D{sortBy},
+		"output": bson.M{output: bson.M{function: bson.M{}}},
+	}
+	if len(partition) > 0 {
+		stage["partitionBy"] = append(bson.D{}, partition...)
+	}
+
+	return stage
+}
+
+// tiebreakExpr is a string that sorts tied players the way rankEntries does:
+// by when they reached their score in first for TiebreakEarliest, then by
+// uuid and name. Times are zero-padded so they compare as strings, and a
+// missing time is math.MaxInt64, after every recorded one.
+func tiebreakExpr(tiebreak, first string) interface{} {
+	parts := bson.A{}
+	if tiebreak == TiebreakEarliest && first != "" {
+		parts = append(parts, bson.M{"$let": bson.M{
+			"vars": bson.M{"time": bson.M{"$toString": bson.M{"$toLong": bson.M{
+				"$ifNull": bson.A{"$stats." + first + ".achieved", int64(math.MaxInt64)},
+			}}}},
+			"in": bson.M{"$substrCP": bson.A{
+				bson.M{"$concat": bson.A{strings.Repeat("0", 20), "$$time"}},
+				bson.M{"$strLenCP": "$$time"},
+				20,
+			}},
+		}}, "\x01")
+	}
+
+	// \x01 sorts before any character a uuid or name contains, so the joined
+	// string orders like the (uuid, name) pair.
+	return bson.M{"$concat": append(parts,
+		bson.M{"$ifNull": bson.A{"$uuid", ""}}, "\x01",
+		bson.M{"$ifNull": bson.A{"$name", ""}},
+	)}
+}
+
+// aggregateExpr is ScoreStats.value as an aggregation expression over a
+// playerScores document.
+func aggregateExpr(field, aggregation string) interface{} {
+	stats := "$stats." + field
+	switch aggregation {
+	case AggregateAvg:
+		return bson.M{"$divide": bson.A{stats + ".sum", stats + ".count"}}
+	case AggregateMax, AggregateMin, AggregateCount, AggregateBest:
+		return stats + "." + aggregation
+	}
+
+	return stats + ".sum"
+}
+
+// rankedPage is a page of a rankedBoard along with the size of the board.
+type rankedPage struct {
+	Total []struct {
+		Count int32 `bson:"count"`
+	} `bson:"total"`
+	Entries []rankedEntry `bson:"entries"`
+}
+
+type rankedEntry struct {
+	LeaderboardEntry `bson:",inline"`
+	Index            int64 `bson:"index"`
+}
+
+// rankedLeaderboard pages through a rankedBoard, looking up the player's own
+// place first for around queries.
+func (s *MongoStore) rankedLeaderboard(ctx context.Context, query LeaderboardQuery) (LeaderboardResult, error) {
+	var result LeaderboardResult
+
+	entries := bson.A{}
+	switch {
+	case query.User != "" && query.Around > 0:
+		page, err := s.rankedPage(ctx, query, bson.A{bson.M{"$match": bson.M{"uuid": query.User}}})
+		if err != nil || len(page.Entries) == 0 {
+			if len(page.Total) > 0 {
+				result.TotalCount = page.Total[0].Count
+			}
+
+			return result, err
+		}
+
+		index := page.Entries[0].Index
+		entries = append(entries, bson.M{"$match": bson.M{"index": bson.M{
+			"$gte": index - int64(query.Around),
+			"$lte": index + int64(query.Around),
+		}}})
+	case query.User != "":
+		entries = append(entries, bson.M{"$match": bson.M{"uuid": query.User}})
+	default:
+		index := bson.M{"$gt": int64(Offset(query.Page, query.Length))}
+		if query.Length >= 0 {
+			index["$lte"] = int64(Offset(query.Page, query.Length) + query.Length)
+		}
+
+		entries = append(entries, bson.M{"$match": bson.M{"index": index}})
+	}
+
+	page, err := s.rankedPage(ctx, query, append(entries, bson.M{"$sort": bson.M{"index": 1}}))
+	if err != nil {
+		return result, err
+	}
+
+	if len(page.Total) > 0 {
+		result.TotalCount = page.Total[0].Count
+	}
+
+	for _, entry := range page.Entries {
+		result.Entries = append(result.Entries, entry.LeaderboardEntry)
+	}
+	result.Entries = scoreEntries(result.Entries, query)
+
+	// A player's own row is a board of one.
+	if query.User != "" && query.Around == 0 {
+		result.TotalCount = int32(len(result.Entries))
+	}
+
+	return result, nil
+}
+
+func (s *MongoStore) rankedPage(ctx context.Context, query LeaderboardQuery, entries bson.A) (rankedPage, error) {
+	var page rankedPage
+
+	builder := rankedBoard(query).Stage("$facet", bson.M{
+		"total":   bson.A{bson.M{"$count": "count"}},
+		"entries": entries,
+	})
+
+	cur, err := s.aggregate(ctx, builder)
+	if err != nil {
+		return page, err
+	}
+	defer cur.Close(ctx)
+
+	if cur.Next(ctx) {
+		if err := cur.Decode(&page); err != nil {
+			return page, err
+		}
+	}
+
+	return page, cur.Err()
+}
+
+func (s *MongoStore) ProfileEvents(ctx context.Context, query ProfileQuery) ([]util.MongoResult, error) {
+	match := bson.M{"$or": bson.A{
+		bson.M{"player_uuid": query.Player},
+		bson.M{"killer_uuid": query.Player},
+	}}
+	if query.Game != "" {
+		match["game_id"] = query.Game
+	}
+	if query.Mode != "" {
+		match["game_mode_id"] = query.Mode
+	}
+
+	builder := pipeline.New().
+		Match(match).
+		Project(eventFields)
+
+	return s.events(ctx, builder)
+}
+
+func (s *MongoStore) ScoreFields(ctx context.Context, game, mode string) ([]string, error) {
+	values, err := s.collection.Distinct(ctx, "score_field", bson.M{
+		"analytic_event_type": "Score",
+		"game_id":             game,
+		"game_mode_id":        mode,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []string
+	for _, value := range values {
+		if field, ok := value.(string); ok && field != "" {
+			fields = append(fields, field)
+		}
+	}
+
+	sort.Strings(fields)
+
+	return fields, nil
+}
+
+func (s *MongoStore) FavoriteMode(ctx context.Context, since int64) (FavoriteMode, error) {
+	var result FavoriteMode
+
+	builder := pipeline.New().
+		Match(bson.M{"time_code": bson.M{"$gt": since}, "analytic_event_type": "Finish"}).
+		Group("$game_mode_id", bson.M{"count": bson.M{"$sum": 1}}).
+		Sort(bson.D{pipeline.Order("count", false)}).
+		Limit(1)
+
+	cur, err := s.aggregate(ctx, builder)
+	if err != nil {
+		return result, err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		if err := cur.Decode(&result); err != nil {
+			return result, err
+		}
+	}
+
+	return result, cur.Err()
+}
+
+// duplicateKey is the server error code for a unique index violation.
+const duplicateKey = 11000
+
+func (s *MongoStore) SaveStandings(ctx context.Context, season string, standings []Standing) error {
+	if len(standings) > 0 {
+		models := make([]mongo.WriteModel, len(standings))
+		for i, standing := range standings {
+			standing.Season = season
+			models[i] = mongo.NewReplaceOneModel().
+				SetFilter(bson.M{"_id": standing.key()}).
+				SetReplacement(standing).
+				SetUpsert(true)
+		}
+
+		if _, err := s.standings.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+			return err
+		}
+	}
+
+	// The marker is written last so a freeze that fails halfway is retried
+	// instead of serving partial standings.
+	_, err := s.seasons.ReplaceOne(ctx,
+		bson.M{"_id": season},
+		seasonMarker{Season: season, FrozenAt: time.Now()},
+		options.Replace().SetUpsert(true),
+	)
+
+	return err
+}
+
+func (s *MongoStore) Frozen(ctx context.Context, season string) (bool, error) {
+	err := s.seasons.FindOne(ctx, bson.M{"_id": season}).Err()
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (s *MongoStore) Standings(ctx context.Context, season string, query LeaderboardQuery) (LeaderboardResult, error) {
+	var result LeaderboardResult
+
+	filter := bson.M{"season": season}
+	if query.Game != "" {
+		filter["game"] = query.Game
+	}
+	if query.Mode != "" {
+		filter["mode"] = query.Mode
+	}
+
+	cur, err := s.standings.Find(ctx, filter, options.Find().SetSort(bson.D{pipeline.Order("_id", true)}))
+	if err != nil {
+		return result, err
+	}
+	defer cur.Close(ctx)
+
+	var standings []Standing
+	for cur.Next(ctx) {
+		var standing Standing
+		if err := cur.Decode(&standing); err != nil {
+			return result, err
+		}
+
+		standings = append(standings, standing)
+	}
+
+	if err := cur.Err(); err != nil {
+		return result, err
+	}
+
+	return rankEntries(scoreEntries(mergeStandings(standings), query), query), nil
+}
+
+func (s *MongoStore) InsertEvents(ctx context.Context, events []util.MongoResult) (InsertResult, error) {
+	var result InsertResult
+
+	documents := make([]interface{}, len(events))
+	for i, event := range events {
+		documents[i] = event
+	}
+
+	duplicates := make(map[int]bool)
+	_, err := s.collection.InsertMany(ctx, documents, options.InsertMany().SetOrdered(false))
+	if bulk, ok := err.(mongo.BulkWriteException); ok && bulk.WriteConcernError == nil {
+		for _, writeError := range bulk.WriteErrors {
+			if writeError.Code != duplicateKey {
+				return result, err
+			}
+
+			duplicates[writeError.Index] = true
+		}
+	} else if err != nil {
+		return result, err
+	}
+
+	for i, event := range events {
+		if duplicates[i] {
+			result.Skipped++
+			continue
+		}
+
+		result.Inserted = append(result.Inserted, event)
+	}
+
+	return result, nil
+}
+
+// statsMarker is the Rollups document recording the last PlayerStats rebuild.
+const statsMarker = "player_stats"
+
+// UpdatePlayerStats recomputes the rollups touched by events from the event
+// log rather than adding the events to them, so a batch applied twice, e.g.
+// after a crash, is still counted once.
+func (s *MongoStore) UpdatePlayerStats(ctx context.Context, events []util.MongoResult) error {
+	touched := rollupStats(events)
+	if len(touched) == 0 {
+		return nil
+	}
+
+	cur, err := s.aggregate(ctx, playerStats(statsMatch(touched)))
+	if err != nil {
+		return err
+	}
+
+	recomputed, err := decodePlayerStats(ctx, cur)
+	if err != nil {
+		return err
+	}
+
+	byID := make(map[string]PlayerStats, len(recomputed))
+	for _, stats := range recomputed {
+		byID[stats.ID] = stats
+	}
+
+	models := make([]mongo.WriteModel, len(touched))
+	for i, rollup := range touched {
+		stats, ok := byID[rollup.ID]
+		if !ok {
+			models[i] = mongo.NewDeleteOneModel().SetFilter(bson.M{"_id": rollup.ID})
+			continue
+		}
+
+		models[i] = mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"_id": rollup.ID}).
+			SetReplacement(stats).
+			SetUpsert(true)
+	}
+
+	_, err = s.stats.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+
+	return err
+}
+
+// statsMatch matches every event behind the given rollups: those of each
+// rollup's game mode where its player died or killed. Other players' rollups
+// built from the same events are incomplete and must be thrown away.
+func statsMatch(rollups []PlayerStats) bson.M {
+	type mode struct{ game, mode string }
+
+	var modes []mode
+	players := make(map[mode][]string)
+	for _, rollup := range rollups {
+		key := mode{rollup.Game, rollup.Mode}
+		if _, ok := players[key]; !ok {
+			modes = append(modes, key)
+		}
+
+		players[key] = append(players[key], rollup.Player)
+	}
+
+	// Rollups of events without a game or mode are stored under "".
+	value := func(value string) interface{} {
+		if value == "" {
+			return bson.M{"$in": bson.A{nil, ""}}
+		}
+
+		return value
+	}
+
+	matches := make(bson.A, len(modes))
+	for i, key := range modes {
+		matches[i] = bson.M{
+			"game_id":      value(key.game),
+			"game_mode_id": value(key.mode),
+			"$or": bson.A{
+				bson.M{"player_uuid": bson.M{"$in": players[key]}},
+				bson.M{"killer_uuid": bson.M{"$in": players[key]}},
+			},
+		}
+	}
+
+	return bson.M{"$or": matches}
+}
+
+// playerStats totals the event log into PlayerStats documents with the same
+// rules as rollupStats, over the events matching match if it isn't nil.
+func playerStats(match bson.M) *pipeline.Builder {
+	row := func(uuid, name, total, amount interface{}) bson.M {
+		return bson.M{"uuid": uuid, "name": name, "total": total, "amount": amount}
+	}
+
+	rows := bson.M{"$cond": bson.A{
+		bson.M{"$eq": bson.A{"$analytic_event_type", "Score"}},
+		bson.A{row("$player_uuid", "$player_name", "$score_field", "$value")},
+		bson.A{
+			row("$player_uuid", "$player_name", VarDeaths, bson.M{"$literal": 1}),
+			row(creditedKillerExpr, "$killer_name", VarKills, bson.M{"$literal": 1}),
+		},
+	}}
+
+	total := bson.M{"uuid": "$uuid", "game": "$game", "mode": "$mode", "total": "$total"}
+	part := func(field string) bson.M {
+		return bson.M{"$ifNull": bson.A{"$_id." + field, ""}}
+	}
+
+	builder := pipeline.New().
+		Match(bson.M{"$or": bson.A{
+			bson.M{"analytic_event_type": "Score", "score_field": pipeline.ValidFieldRegex()},
+			bson.M{"analytic_event_type": "Death"},
+		}})
+	if match != nil {
+		builder.Match(match)
+	}
+
+	builder.
+		Project(bson.M{"game": "$game_id", "mode": "$game_mode_id", "rows": rows}).
+		Unwind("$rows", "").
+		Project(bson.M{
+			"game":   1,
+			"mode":   1,
+			"uuid":   "$rows.uuid",
+			"name":   "$rows.name",
+			"total":  "$rows.total",
+			"amount": "$rows.amount",
+		}).
+		Match(bson.M{"uuid": bson.M{"$nin": bson.A{nil, ""}}}).
+		Group(total, bson.M{
+			"name":   bson.M{"$last": "$name"},
+			"amount": bson.M{"$sum": "$amount"},
+		}).
+		Group(bson.M{"uuid": "$_id.uuid", "game": "$_id.game", "mode": "$_id.mode"}, bson.M{
+			"name":   bson.M{"$last": "$name"},
+			"totals": bson.M{"$push": bson.M{"k": "$_id.total", "v": "$amount"}},
+		}).
+		Project(bson.M{
+			"_id":    bson.M{"$concat": bson.A{part("uuid"), "\x00", part("game"), "\x00", part("mode")}},
+			"player": "$_id.uuid",
+			"game":   part("game"),
+			"mode":   part("mode"),
+			"name":   1,
+			"totals": bson.M{"$arrayToObject": "$totals"},
+		})
+
+	return builder
+}
+
+// RebuildPlayerStats recomputes the rollups and swaps them in with $out, so
+// readers see either the old rollups or the new ones. Updates made to the old
+// collection while the rebuild ran are lost with it, so the events written
+// since it started are replayed onto the new one.
+func (s *MongoStore) RebuildPlayerStats(ctx context.Context) error {
+	start := time.Now().Add(-replayMargin)
+
+	cur, err := s.aggregate(ctx, playerStats(nil).Stage("$out", s.stats.Name()))
+	if err != nil {
+		return err
+	}
+	cur.Close(ctx)
+
+	if err := s.replaySince(ctx, start, s.UpdatePlayerStats); err != nil {
+		return err
+	}
+
+	_, err = s.rollups.ReplaceOne(ctx,
+		bson.M{"_id": statsMarker},
+		bson.M{"_id": statsMarker, "built_at": time.Now()},
+		options.Replace().SetUpsert(true),
+	)
+
+	return err
+}
+
+// RecomputePlayerStats totals the event log into rollups without storing
+// them, to check the stored ones against.
+func (s *MongoStore) RecomputePlayerStats(ctx context.Context) ([]PlayerStats, error) {
+	cur, err := s.aggregate(ctx, playerStats(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	return decodePlayerStats(ctx, cur)
+}
+
+// AllPlayerStats returns every stored rollup.
+func (s *MongoStore) AllPlayerStats(ctx context.Context) ([]PlayerStats, error) {
+	cur, err := s.stats.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	return decodePlayerStats(ctx, cur)
+}
+
+func decodePlayerStats(ctx context.Context, cur *mongo.Cursor) ([]PlayerStats, error) {
+	defer cur.Close(ctx)
+
+	var results []PlayerStats
+	for cur.Next(ctx) {
+		var result PlayerStats
+		if err := cur.Decode(&result); err != nil {
+			return nil, err
+		}
+
+		results = append(results, result)
+	}
+
+	return results, cur.Err()
+}
+
+func (s *MongoStore) StatsBuilt(ctx context.Context) (bool, error) {
+	err := s.rollups.FindOne(ctx, bson.M{"_id": statsMarker}).Err()
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (s *MongoStore) PlayerStats(ctx context.Context, query ProfileQuery) ([]PlayerStats, error) {
+	filter := bson.M{"player": query.Player}
+	if query.Game != "" {
+		filter["game"] = query.Game
+	}
+	if query.Mode != "" {
+		filter["mode"] = query.Mode
+	}
+
+	cur, err := s.stats.Find(ctx, filter, options.Find().SetSort(bson.D{pipeline.Order("_id", true)}))
+	if err != nil {
+		return nil, err
+	}
+
+	return decodePlayerStats(ctx, cur)
+}
+
+// EachEvent streams the events with time codes in [from, to), oldest first,
+// to handle in batches of up to size events.
+func (s *MongoStore) EachEvent(ctx context.Context, from, to int64, size int, handle func(events []util.MongoResult) error) error {
+	filter := bson.M{}
+	if window := timeCodeRange(from, to); window != nil {
+		filter["time_code"] = window
+	}
+
+	cur, err := s.collection.Find(ctx, filter, options.Find().SetSort(bson.D{pipeline.Order("time_code", true)}))
+	if err != nil {
+		return err
+	}
+
+	return eachBatch(ctx, cur, size, handle)
+}
+
+// eachBatch decodes a cursor of events into batches of up to size events.
+func eachBatch(ctx context.Context, cur *mongo.Cursor, size int, handle func(events []util.MongoResult) error) error {
+	defer cur.Close(ctx)
+
+	var batch []util.MongoResult
+	for cur.Next(ctx) {
+		var event util.MongoResult
+		if err := cur.Decode(&event); err != nil {
+			return err
+		}
+
+		if batch = append(batch, event); len(batch) == size {
+			if err := handle(batch); err != nil {
+				return err
+			}
+			batch = nil
+		}
+	}
+
+	if err := cur.Err(); err != nil {
+		return err
+	}
+
+	if len(batch) > 0 {
+		return handle(batch)
+	}
+
+	return nil
+}
+
+const (
+	// replayMargin is how far before a rebuild started events are replayed,
+	// to cover clock skew between this process and whoever generated their
+	// ids.
+	replayMargin = time.Minute
+	replayBatch  = 1000
+)
+
+// replaySince hands the events whose ids were generated at or after since to
+// apply. A rebuild swapping in a collection with $out uses it to bring back
+// the updates that hooks made to the old collection while it ran; apply must
+// be idempotent, as events from just before the rebuild are applied again.
+func (s *MongoStore) replaySince(ctx context.Context, since time.Time, apply func(ctx context.Context, events []util.MongoResult) error) error {
+	// An ObjectID starts with the big-endian seconds it was generated at, so
+	// one with nothing else set is the lowest id generated from then on.
+	var first primitive.ObjectID
+	binary.BigEndian.PutUint32(first[:4], uint32(since.Unix()))
+
+	cur, err := s.collection.Find(ctx, bson.M{"_id": bson.M{"$gte": first}})
+	if err != nil {
+		return err
+	}
+
+	return eachBatch(ctx, cur, replayBatch, func(events []util.MongoResult) error {
+		return apply(ctx, events)
+	})
+}
+
+// summariesMarker is the Rollups document recording the last GameSummaries
+// rebuild.
+const summariesMarker = "game_summaries"
+
+// summaryKey identifies the summary of one finished game. Finish events that
+// differ only in their winners or losers share a summary.
+func summaryKey(summary GameSummary) bson.D {
+	return bson.D{
+		{Key: "instance_id", Value: summary.InstanceID},
+		{Key: "game_id", Value: summary.GameID},
+		{Key: "game_mode_id", Value: summary.GameModeID},
+		{Key: "end_time", Value: summary.EndTime},
+	}
+}
+
+// summaryDocument is a GameSummary as stored, with the players who took part
+// so a player's games can be found through an index.
+type summaryDocument struct {
+	ID          bson.D `bson:"_id"`
+	GameSummary `bson:",inline"`
+	Players     []string `bson:"players"`
+}
+
+func (s *MongoStore) UpdateGameSummaries(ctx context.Context, events []util.MongoResult) error {
+	var models []mongo.WriteModel
+	for _, summary := range summarise(finishEvents(events)) {
+		models = append(models, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"_id": summaryKey(summary)}).
+			SetReplacement(summaryDocument{ID: summaryKey(summary), GameSummary: summary, Players: summaryPlayers(summary)}).
+			SetUpsert(true))
+	}
+
+	if len(models) == 0 {
+		return nil
+	}
+
+	_, err := s.summaries.BulkWrite(ctx, models)
+
+	return err
+}
+
+// RebuildGameSummaries recomputes the summaries and swaps them in with $out,
+// replaying recent events like RebuildPlayerStats.
+func (s *MongoStore) RebuildGameSummaries(ctx context.Context) error {
+	start := time.Now().Add(-replayMargin)
+
+	key := bson.D{
+		{Key: "instance_id", Value: "$instance_id"},
+		{Key: "game_id", Value: "$game_id"},
+		{Key: "game_mode_id", Value: "$game_mode_id"},
+		{Key: "end_time", Value: "$time_code"},
+	}
+
+	builder := pipeline.New().
+		Match(bson.M{"analytic_event_type": "Finish"}).
+		Group(key, bson.M{
+			"winners": bson.M{"$last": "$winners"},
+			"losers":  bson.M{"$last": "$losers"},
+		}).
+		Project(bson.M{
+			"game_id":      "$_id.game_id",
+			"game_mode_id": "$_id.game_mode_id",
+			"instance_id":  "$_id.instance_id",
+			"end_time":     "$_id.end_time",
+			"winners":      1,
+			"losers":       1,
+			"players":      bson.M{"$concatArrays": bson.A{pipeline.Keys("winners"), pipeline.Keys("losers")}},
+		}).
+		Stage("$out", s.summaries.Name())
+
+	cur, err := s.aggregate(ctx, builder)
+	if err != nil {
+		return err
+	}
+	cur.Close(ctx)
+
+	if err := s.replaySince(ctx, start, s.UpdateGameSummaries); err != nil {
+		return err
+	}
+
+	_, err = s.rollups.ReplaceOne(ctx,
+		bson.M{"_id": summariesMarker},
+		bson.M{"_id": summariesMarker, "built_at": time.Now()},
+		options.Replace().SetUpsert(true),
+	)
+
+	return err
+}
+
+func (s *MongoStore) SummariesBuilt(ctx context.Context) (bool, error) {
+	err := s.rollups.FindOne(ctx, bson.M{"_id": summariesMarker}).Err()
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (s *MongoStore) SummaryGameList(ctx context.Context, query GameListQuery) ([]GameSummary, error) {
+	filter := bson.M{}
+	if query.Game != "" {
+		filter["game_id"] = query.Game
+
+		if query.Mode != "" {
+			filter["game_mode_id"] = query.Mode
+		}
+	}
+
+	return s.findSummaries(ctx, filter, query.Page, query.Length)
+}
+
+func (s *MongoStore) SummaryRecentGames(ctx context.Context, query RecentQuery) ([]GameSummary, error) {
+	return s.findSummaries(ctx, bson.M{"players": query.Player}, query.Page, query.Length)
+}
+
+// findSummaries returns a page of the matching summaries, newest first.
+func (s *MongoStore) findSummaries(ctx context.Context, filter bson.M, page, length int) ([]GameSummary, error) {
+	skip := Offset(page, length)
+	if skip < 0 {
+		skip = 0
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{pipeline.Order("end_time", false)}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(length))
+
+	cur, err := s.summaries.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var results []GameSummary
+	for cur.Next(ctx) {
+		var result GameSummary
+		if err := cur.Decode(&result); err != nil {
+			return nil, err
+		}
+
+		results = append(results, result)
+	}
+
+	return results, cur.Err()
+}
+
+// streamMarker is the Rollups document holding the resume token of the
+// Events change stream.
+const streamMarker = "events_stream"
+
+// Server error codes meaning a change stream can't resume where it left off.
+const (
+	changeStreamFatal       = 280
+	changeStreamHistoryLost = 286
+)
+
+// ErrHistoryLost is returned by TailEvents when the saved resume token has
+// fallen off the oplog. The token is discarded, so the next call starts from
+// the present; anything derived from events has to be rebuilt.
+var ErrHistoryLost = errors.New("change stream history lost")
+
+type streamToken struct {
+	Token bson.Raw `bson:"token"`
+}
+
+// TailEvents follows inserts into the Events collection until ctx is done, the
+// stream fails or handle returns an error. Events are handed to handle in
+// batches of up to size, or of whatever arrived within linger of a batch's
+// first event. It resumes after the last batch handled by a previous call, or
+// starts from the present if there was none. The resume token is saved once
+// handle returns for a batch, so a batch that failed or was cut short by a
+// crash is handled again: handle must be idempotent.
+func (s *MongoStore) TailEvents(ctx context.Context, size int, linger time.Duration, handle func(events []util.MongoResult) error) error {
+	opts := options.ChangeStream()
+
+	var saved streamToken
+	err := s.rollups.FindOne(ctx, bson.M{"_id": streamMarker}).Decode(&saved)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	if saved.Token != nil {
+		opts.SetResumeAfter(saved.Token)
+	}
+
+	stream, err := s.collection.Watch(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"operationType": "insert"}}},
+	}, opts)
+	if err != nil {
+		return s.streamError(ctx, err)
+	}
+	defer stream.Close(ctx)
+
+	type change struct {
+		token bson.Raw
+		event util.MongoResult
+	}
+
+	// Next blocks until an event arrives, so the stream is read on its own
+	// goroutine to let a batch be flushed once it has lingered long enough.
+	reading, cancel := context.WithCancel(ctx)
+	changes := make(chan change)
+	failed := make(chan error, 1)
+	go func() {
+		defer close(changes)
+
+		for stream.Next(reading) {
+			var decoded struct {
+				ID           bson.Raw         `bson:"_id"`
+				FullDocument util.MongoResult `bson:"fullDocument"`
+			}
+			if err := stream.Decode(&decoded); err != nil {
+				failed <- err
+				return
+			}
+
+			select {
+			case changes <- change{token: append(bson.Raw(nil), decoded.ID...), event: decoded.FullDocument}:
+			case <-reading.Done():
+				failed <- reading.Err()
+				return
+			}
+		}
+
+		failed <- s.streamError(ctx, stream.Err())
+	}()
+
+	// The stream is only closed once the goroutine is done with it.
+	defer func() {
+		cancel()
+		for range changes {
+		}
+	}()
+
+	var batch []util.MongoResult
+	var token bson.Raw
+	var timeout <-chan time.Time
+
+	flush := func() error {
+		timeout = nil
+		if len(batch) == 0 {
+			return nil
+		}
+
+		if err := handle(batch); err != nil {
+			return err
+		}
+		batch = nil
+
+		_, err := s.rollups.ReplaceOne(ctx,
+			bson.M{"_id": streamMarker},
+			bson.M{"_id": streamMarker, "token": token},
+			options.Replace().SetUpsert(true),
+		)
+
+		return err
+	}
+
+	for {
+		select {
+		case next, ok := <-changes:
+			if !ok {
+				// Events read before the stream ended are still handed over.
+				if err := flush(); err != nil {
+					return err
+				}
+
+				if err := <-failed; err != nil {
+					return err
+				}
+
+				return ctx.Err()
+			}
+
+			batch = append(batch, next.event)
+			token = next.token
+			if len(batch) == 1 {
+				timeout = time.After(linger)
+			}
+
+			if len(batch) >= size {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		case <-timeout:
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// streamError turns a change stream that can't resume into ErrHistoryLost,
+// dropping the token it was resumed from.
+func (s *MongoStore) streamError(ctx context.Context, err error) error {
+	command, ok := err.(mongo.CommandError)
+	if !ok || (command.Code != changeStreamHistoryLost && command.Code != changeStreamFatal) {
+		return err
+	}
+
+	if _, err := s.rollups.DeleteOne(ctx, bson.M{"_id": streamMarker}); err != nil {
+		return err
+	}
+
+	return ErrHistoryLost
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"LeaderboardsBackend/util"
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testMongo connects to the MongoDB 5.0+ server in MONGO_TEST_URI, skipping
+// the test when it isn't set. Tests get an empty Events collection in a
+// database of their own, dropped when they finish.
+func testMongo(t *testing.T, events []util.MongoResult) (*MongoStore, func()) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	database := client.Database(fmt.Sprintf("LeaderboardsTest%d", time.Now().UnixNano()))
+	s := &MongoStore{collection: database.Collection("Events")}
+
+	var documents []interface{}
+	for _, event := range events {
+		documents = append(documents, event)
+	}
+
+	if _, err := s.collection.InsertMany(ctx, documents); err != nil {
+		t.Fatal(err)
+	}
+
+	return s, func() {
+		database.Drop(context.Background())
+		client.Disconnect(context.Background())
+	}
+}
+
+type rankedRow struct {
+	ID       string
+	Name     string
+	Position int32
+	Scores   map[string]float64
+}
+
+func rows(result LeaderboardResult) []rankedRow {
+	var found []rankedRow
+	for _, entry := range result.Entries {
+		found = append(found, rankedRow{entry.ID, entry.Name, entry.Position, entry.Scores})
+	}
+
+	return found
+}
+
+// TestRankedLeaderboard checks that the ranking pipeline agrees with
+// rankEntries, including players tied on several filters and a player who
+// played under two names.
+func TestRankedLeaderboard(t *testing.T) {
+	events := []util.MongoResult{
+		score("1", "alice", "kills", 5, 1000),
+		score("1", "alice", "wins", 1, 1000),
+		score("1", "bob", "kills", 5, 900),
+		score("1", "bob", "wins", 1, 900),
+		score("1", "carol", "kills", 5, 1100),
+		score("1", "carol", "wins", 2, 1100),
+		score("2", "dave", "kills", 7, 2000),
+		score("2", "erin", "kills", 1, 2000),
+		score("2", "erin", "wins", 3, 2000),
+		score("2", "frank", "wins", 4, 2000),
+	}
+	renamed := score("2", "alice", "kills", 2, 2100)
+	renamed.PlayerName = "alice2"
+	events = append(events, renamed)
+
+	s, done := testMongo(t, events)
+	defer done()
+
+	memory := NewMemoryStore(events)
+
+	var queries []LeaderboardQuery
+	for _, filters := range [][]string{nil, {"kills"}, {"-kills"}, {"kills", "wins"}, {"kills", "-wins"}} {
+		for _, ranking := range []string{RankOrdinal, RankCompetition, RankDense} {
+			for _, tiebreak := range []string{TiebreakUUID, TiebreakEarliest} {
+				queries = append(queries,
+					LeaderboardQuery{Filters: filters, Ranking: ranking, Tiebreak: tiebreak, Page: 1, Length: 100},
+					LeaderboardQuery{Filters: filters, Ranking: ranking, Tiebreak: tiebreak, Page: 2, Length: 2},
+					LeaderboardQuery{Filters: filters, Ranking: ranking, Tiebreak: tiebreak, User: "carol", Page: 1, Length: 100},
+					LeaderboardQuery{Filters: filters, Ranking: ranking, Tiebreak: tiebreak, User: "bob", Around: 1},
+				)
+			}
+		}
+	}
+
+	for _, query := range queries {
+		want, err := memory.Leaderboard(context.Background(), query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := s.rankedLeaderboard(context.Background(), query)
+		if err != nil {
+			t.Fatalf("%+v: %v", query, err)
+		}
+
+		if !reflect.DeepEqual(rows(got), rows(want)) || got.TotalCount != want.TotalCount {
+			t.Errorf("%+v:\ngot  %+v of %d\nwant %+v of %d", query, rows(got), got.TotalCount, rows(want), want.TotalCount)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package store
+
+import (
+	"LeaderboardsBackend/util"
+)
+
+// Outcomes are a player's match results, counted from Death and Finish events
+// rather than Score events. Wins and Games count distinct game instances.
+type Outcomes struct {
+	Kills  int32 `bson:"kills"`
+	Deaths int32 `bson:"deaths"`
+	Wins   int32 `bson:"wins"`
+	Games  int32 `bson:"games"`
+}
+
+// Outcome variables are available to every metric. A score field with the same
+// name takes precedence, so games that score their own kills keep them.
+const (
+	VarKills  = "kills"
+	VarDeaths = "deaths"
+	VarWins   = "wins"
+	VarGames  = "games"
+)
+
+func (o Outcomes) add(other Outcomes) Outcomes {
+	return Outcomes{
+		Kills:  o.Kills + other.Kills,
+		Deaths: o.Deaths + other.Deaths,
+		Wins:   o.Wins + other.Wins,
+		Games:  o.Games + other.Games,
+	}
+}
+
+func (o Outcomes) variable(name string) float64 {
+	switch name {
+	case VarKills:
+		return float64(o.Kills)
+	case VarDeaths:
+		return float64(o.Deaths)
+	case VarWins:
+		return float64(o.Wins)
+	case VarGames:
+		return float64(o.Games)
+	}
+
+	return 0
+}
+
+// creditedKiller returns the player a Death event counts as a kill for, or
+// "" when there is none. Killing yourself is only a death. Every store and
+// rollup counts kills this way; MongoStore uses creditedKillerExpr.
+func creditedKiller(event util.MongoResult) string {
+	if event.KillerUUID == event.PlayerUUID {
+		return ""
+	}
+
+	return event.KillerUUID
+}
+
+// playerOutcomes is one player's Outcomes as collected by a store.
+type playerOutcomes struct {
+	ID       string `bson:"_id"`
+	Name     string `bson:"name"`
+	Outcomes `bson:",inline"`
+}
+
+// onlyPlayers keeps the entries of the given players, or every entry when
+// players is empty.
+func onlyPlayers(entries []LeaderboardEntry, players []string) []LeaderboardEntry {
+	if len(players) == 0 {
+		return entries
+	}
+
+	wanted := make(map[string]bool, len(players))
+	for _, player := range players {
+		wanted[player] = true
+	}
+
+	var kept []LeaderboardEntry
+	for _, entry := range entries {
+		if wanted[entry.ID] {
+			kept = append(kept, entry)
+		}
+	}
+
+	return kept
+}
+
+// mergeOutcomes attaches outcomes to the entries of the same players, adding
+// entries for players who have outcomes but never scored.
+func mergeOutcomes(entries []LeaderboardEntry, outcomes []playerOutcomes) []LeaderboardEntry {
+	index := make(map[string][]int)
+	for i, entry := range entries {
+		index[entry.ID] = append(index[entry.ID], i)
+	}
+
+	for _, player := range outcomes {
+		matched, ok := index[player.ID]
+		if !ok {
+			entries = append(entries, LeaderboardEntry{
+				ID:       player.ID,
+				Name:     player.Name,
+				Stats:    make(map[string]ScoreStats),
+				Outcomes: player.Outcomes,
+			})
+			continue
+		}
+
+		for _, i := range matched {
+			entries[i].Outcomes = player.Outcomes
+		}
+	}
+
+	return entries
+}
+
+// collectOutcomes counts Outcomes per player from Death and Finish events, in
+// the order players first appear.
+func collectOutcomes(events []util.MongoResult) []playerOutcomes {
+	index := make(map[string]int)
+	wins := make(map[string]map[string]bool)
+	games := make(map[string]map[string]bool)
+
+	var players []playerOutcomes
+	player := func(uuid, name string) *playerOutcomes {
+		i, ok := index[uuid]
+		if !ok {
+			i = len(players)
+			index[uuid] = i
+			players = append(players, playerOutcomes{ID: uuid, Name: name})
+			wins[uuid] = make(map[string]bool)
+			games[uuid] = make(map[string]bool)
+		}
+
+		return &players[i]
+	}
+
+	for _, event := range events {
+		switch event.AnalyticEventType {
+		case "Death":
+			if event.PlayerUUID != "" {
+				player(event.PlayerUUID, event.PlayerName).Deaths++
+			}
+			if killer := creditedKiller(event); killer != "" {
+				player(killer, event.KillerName).Kills++
+			}
+		case "Finish":
+			for uuid, name := range event.Winners {
+				player(uuid, name)
+				wins[uuid][event.InstanceID] = true
+				games[uuid][event.InstanceID] = true
+			}
+			for uuid, name := range event.Losers {
+				player(uuid, name)
+				games[uuid][event.InstanceID] = true
+			}
+		}
+	}
+
+	for i := range players {
+		players[i].Wins = int32(len(wins[players[i].ID]))
+		players[i].Games = int32(len(games[players[i].ID]))
+	}
+
+	return players
+}
+
+// scoreEntries computes the scores a query ranks by: each field's
+// aggregation, then every metric, dropping players below the query's minimum
+// number of games.
+func scoreEntries(entries []LeaderboardEntry, query LeaderboardQuery) []LeaderboardEntry {
+	entries = aggregateScores(entries, query.Aggregations)
+
+	var kept []LeaderboardEntry
+	for _, entry := range entries {
+		if entry.Outcomes.Games < int32(query.MinGames) {
+			continue
+		}
+
+		// Metrics only see score fields and outcomes, never each other.
+		values := make(map[string]float64, len(query.Metrics))
+		for name, expression := range query.Metrics {
+			values[name] = expression.Eval(func(variable string) float64 {
+				if value, ok := entry.Scores[variable]; ok {
+					return value
+				}
+
+				return entry.Outcomes.variable(variable)
+			})
+		}
+
+		for name, value := range values {
+			entry.Scores[name] = value
+		}
+
+		kept = append(kept, entry)
+	}
+
+	return kept
+}
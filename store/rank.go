@@ -0,0 +1,175 @@
+package store
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Ranking modes decide the positions of players tied on every filter.
+// Positions are zero-based, so competition ranking gives 0, 1, 1, 3 and dense
+// ranking 0, 1, 1, 2, while ordinal ranking gives every player their own
+// position, breaking ties with the tiebreak.
+const (
+	RankOrdinal     = "ordinal"
+	RankCompetition = "competition"
+	RankDense       = "dense"
+)
+
+// Tiebreaks order tied players. TiebreakEarliest puts whoever reached their
+// score in the first filter first, TiebreakUUID orders them by uuid. Both
+// fall back to the uuid and then the name, so the order is always
+// deterministic whatever order the store returned players in.
+const (
+	TiebreakUUID     = "uuid"
+	TiebreakEarliest = "earliest"
+)
+
+// ValidateRanking checks a ranking mode and tiebreak, either of which may be
+// left empty for the default.
+func ValidateRanking(ranking, tiebreak string) error {
+	switch ranking {
+	case "", RankOrdinal, RankCompetition, RankDense:
+	default:
+		return fmt.Errorf("unknown ranking %q", ranking)
+	}
+
+	switch tiebreak {
+	case "", TiebreakUUID, TiebreakEarliest:
+	default:
+		return fmt.Errorf("unknown tiebreak %q", tiebreak)
+	}
+
+	return nil
+}
+
+// rankEntries applies the filters, ordering, positions and paging of a
+// leaderboard query to already aggregated entries.
+func rankEntries(entries []LeaderboardEntry, query LeaderboardQuery) LeaderboardResult {
+	var result LeaderboardResult
+
+	var first string
+	if len(query.Filters) > 0 {
+		first = strings.TrimPrefix(query.Filters[0], "-")
+
+		var kept []LeaderboardEntry
+		for _, entry := range entries {
+			if _, ok := entry.Scores[first]; ok {
+				kept = append(kept, entry)
+			}
+		}
+		entries = kept
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if c := compareScores(entries[i], entries[j], query.Filters); c != 0 {
+			return c < 0
+		}
+
+		if query.Tiebreak == TiebreakEarliest {
+			a, b := achieved(entries[i], first), achieved(entries[j], first)
+			if a != b {
+				return a < b
+			}
+		}
+
+		if entries[i].ID != entries[j].ID {
+			return entries[i].ID < entries[j].ID
+		}
+
+		return entries[i].Name < entries[j].Name
+	})
+
+	positions := make([]int32, len(entries))
+	for i := range entries {
+		positions[i] = int32(i)
+		if i == 0 || query.Ranking == "" || query.Ranking == RankOrdinal {
+			continue
+		}
+
+		if compareScores(entries[i-1], entries[i], query.Filters) == 0 {
+			positions[i] = positions[i-1]
+		} else if query.Ranking == RankDense {
+			positions[i] = positions[i-1] + 1
+		}
+	}
+
+	if query.User != "" && query.Around > 0 {
+		for i, entry := range entries {
+			if entry.ID != query.User {
+				continue
+			}
+
+			start, end := i-query.Around, i+query.Around+1
+			if start < 0 {
+				start = 0
+			}
+			if end > len(entries) {
+				end = len(entries)
+			}
+
+			for j := start; j < end; j++ {
+				entry := entries[j]
+				entry.Position = positions[j]
+				result.Entries = append(result.Entries, entry)
+			}
+
+			break
+		}
+
+		result.TotalCount = int32(len(entries))
+
+		return result
+	}
+
+	var ranked []LeaderboardEntry
+	for i, entry := range entries {
+		entry.Position = positions[i]
+
+		if query.User == "" || entry.ID == query.User {
+			ranked = append(ranked, entry)
+		}
+	}
+
+	if len(ranked) == 0 {
+		return result
+	}
+
+	start, end := bounds(len(ranked), query.Page, query.Length)
+	result.Entries = ranked[start:end]
+	result.TotalCount = int32(len(ranked))
+
+	return result
+}
+
+// compareScores orders two entries by the filters, returning a negative
+// number if a ranks above b and zero if they are tied on every filter.
+func compareScores(a, b LeaderboardEntry, filters []string) int {
+	for _, filter := range filters {
+		field := strings.TrimPrefix(filter, "-")
+
+		x, y := a.Scores[field], b.Scores[field]
+		if x == y {
+			continue
+		}
+
+		if (x > y) != strings.HasPrefix(filter, "-") {
+			return -1
+		}
+
+		return 1
+	}
+
+	return 0
+}
+
+// achieved is when an entry reached its score in field. Entries without a
+// recorded time sort after every other.
+func achieved(entry LeaderboardEntry, field string) int64 {
+	if stats, ok := entry.Stats[field]; ok {
+		return stats.Achieved
+	}
+
+	return math.MaxInt64
+}
@@ -0,0 +1,155 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+)
+
+func entry(id string, score float64) LeaderboardEntry {
+	return LeaderboardEntry{ID: id, Name: id, Scores: map[string]float64{"kills": score}}
+}
+
+func TestRankEntriesTies(t *testing.T) {
+	tests := []struct {
+		ranking   string
+		positions []int32
+	}{
+		{"", []int32{0, 1, 2, 3}},
+		{RankOrdinal, []int32{0, 1, 2, 3}},
+		{RankCompetition, []int32{0, 1, 1, 3}},
+		{RankDense, []int32{0, 1, 1, 2}},
+	}
+
+	for _, test := range tests {
+		entries := []LeaderboardEntry{entry("d", 1), entry("c", 5), entry("b", 5), entry("a", 9)}
+
+		result := rankEntries(entries, LeaderboardQuery{Filters: []string{"kills"}, Page: 1, Length: 100, Ranking: test.ranking})
+
+		ids, positions := ranked(result)
+		if want := []string{"a", "b", "c", "d"}; !reflect.DeepEqual(ids, want) {
+			t.Errorf("%q: got order %v, want %v", test.ranking, ids, want)
+		}
+		if !reflect.DeepEqual(positions, test.positions) {
+			t.Errorf("%q: got positions %v, want %v", test.ranking, positions, test.positions)
+		}
+		if result.TotalCount != 4 {
+			t.Errorf("%q: got total %d, want 4", test.ranking, result.TotalCount)
+		}
+	}
+}
+
+func TestRankEntriesAscending(t *testing.T) {
+	entries := []LeaderboardEntry{entry("a", 30), entry("b", 10), entry("c", 20)}
+
+	ids, _ := ranked(rankEntries(entries, LeaderboardQuery{Filters: []string{"-kills"}, Page: 1, Length: 100}))
+	if want := []string{"b", "c", "a"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got %v, want %v", ids, want)
+	}
+}
+
+func TestRankEntriesTiebreakEarliest(t *testing.T) {
+	late, early, unknown := entry("a", 5), entry("b", 5), entry("c", 5)
+	late.Stats = map[string]ScoreStats{"kills": {Achieved: 200}}
+	early.Stats = map[string]ScoreStats{"kills": {Achieved: 100}}
+
+	entries := []LeaderboardEntry{late, unknown, early}
+
+	ids, _ := ranked(rankEntries(entries, LeaderboardQuery{Filters: []string{"kills"}, Page: 1, Length: 100, Tiebreak: TiebreakEarliest}))
+	if want := []string{"b", "a", "c"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("earliest: got %v, want %v", ids, want)
+	}
+
+	ids, _ = ranked(rankEntries(entries, LeaderboardQuery{Filters: []string{"kills"}, Page: 1, Length: 100, Tiebreak: TiebreakUUID}))
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("uuid: got %v, want %v", ids, want)
+	}
+}
+
+func TestRankEntriesSkipsPlayersWithoutField(t *testing.T) {
+	entries := []LeaderboardEntry{
+		entry("a", 1),
+		{ID: "b", Name: "b", Scores: map[string]float64{"wins": 3}},
+	}
+
+	ids, _ := ranked(rankEntries(entries, LeaderboardQuery{Filters: []string{"kills"}, Page: 1, Length: 100}))
+	if want := []string{"a"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got %v, want %v", ids, want)
+	}
+}
+
+func TestRankEntriesPaging(t *testing.T) {
+	var entries []LeaderboardEntry
+	for i, id := range []string{"a", "b", "c", "d", "e"} {
+		entries = append(entries, entry(id, float64(10-i)))
+	}
+
+	result := rankEntries(entries, LeaderboardQuery{Filters: []string{"kills"}, Page: 2, Length: 2})
+
+	ids, positions := ranked(result)
+	if want := []string{"c", "d"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got %v, want %v", ids, want)
+	}
+	if want := []int32{2, 3}; !reflect.DeepEqual(positions, want) {
+		t.Errorf("got positions %v, want %v", positions, want)
+	}
+	if result.TotalCount != 5 {
+		t.Errorf("got total %d, want 5", result.TotalCount)
+	}
+}
+
+func TestRankEntriesUser(t *testing.T) {
+	entries := []LeaderboardEntry{entry("a", 9), entry("b", 5), entry("c", 1)}
+
+	result := rankEntries(entries, LeaderboardQuery{Filters: []string{"kills"}, Page: 1, Length: 100, User: "b"})
+
+	ids, positions := ranked(result)
+	if !reflect.DeepEqual(ids, []string{"b"}) || !reflect.DeepEqual(positions, []int32{1}) {
+		t.Errorf("got %v at %v, want [b] at [1]", ids, positions)
+	}
+}
+
+func TestRankEntriesAround(t *testing.T) {
+	var entries []LeaderboardEntry
+	for i, id := range []string{"a", "b", "c", "d", "e", "f"} {
+		entries = append(entries, entry(id, float64(10-i)))
+	}
+
+	tests := []struct {
+		user      string
+		around    int
+		ids       []string
+		positions []int32
+	}{
+		{"c", 1, []string{"b", "c", "d"}, []int32{1, 2, 3}},
+		{"a", 2, []string{"a", "b", "c"}, []int32{0, 1, 2}},
+		{"f", 2, []string{"d", "e", "f"}, []int32{3, 4, 5}},
+		{"nobody", 2, nil, nil},
+	}
+
+	for _, test := range tests {
+		result := rankEntries(entries, LeaderboardQuery{Filters: []string{"kills"}, Page: 1, Length: 100, User: test.user, Around: test.around})
+
+		ids, positions := ranked(result)
+		if !reflect.DeepEqual(ids, test.ids) || !reflect.DeepEqual(positions, test.positions) {
+			t.Errorf("%s±%d: got %v at %v, want %v at %v", test.user, test.around, ids, positions, test.ids, test.positions)
+		}
+		if result.TotalCount != 6 {
+			t.Errorf("%s±%d: got total %d, want 6", test.user, test.around, result.TotalCount)
+		}
+	}
+}
+
+func TestValidateRanking(t *testing.T) {
+	if err := ValidateRanking("", ""); err != nil {
+		t.Errorf("defaults: %v", err)
+	}
+	if err := ValidateRanking(RankDense, TiebreakEarliest); err != nil {
+		t.Errorf("dense, earliest: %v", err)
+	}
+	if err := ValidateRanking("olympic", ""); err == nil {
+		t.Error("expected an error for an unknown ranking")
+	}
+	if err := ValidateRanking("", "coin"); err == nil {
+		t.Error("expected an error for an unknown tiebreak")
+	}
+}
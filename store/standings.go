@@ -0,0 +1,81 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// StandingsStore keeps the final standings of finished seasons. Standings are
+// written once when a season is frozen and outlive the events they were
+// computed from.
+type StandingsStore interface {
+	// SaveStandings stores the standings of a season and marks it frozen.
+	// Saving the same standings again is harmless, so replicas racing to
+	// freeze a season can't duplicate them.
+	SaveStandings(ctx context.Context, season string, standings []Standing) error
+	// Frozen reports whether a season's standings have been saved.
+	Frozen(ctx context.Context, season string) (bool, error)
+	// Standings ranks the frozen standings of a season for a leaderboard
+	// query.
+	Standings(ctx context.Context, season string, query LeaderboardQuery) (LeaderboardResult, error)
+}
+
+// Standing is one player's final score stats in a game mode of a season.
+type Standing struct {
+	Season string                `bson:"season"`
+	Game   string                `bson:"game"`
+	Mode   string                `bson:"mode"`
+	ID     string                `bson:"uuid"`
+	Name   string                `bson:"name"`
+	Stats  map[string]ScoreStats `bson:"stats"`
+	// Outcomes are only counted within the standing's game mode.
+	Outcomes Outcomes `bson:"outcomes"`
+}
+
+// key is the unique id of a standing, used to upsert it.
+func (s Standing) key() string {
+	return strings.Join([]string{s.Season, s.Game, s.Mode, s.ID}, "\x00")
+}
+
+// seasonMarker records that a season's standings are complete.
+type seasonMarker struct {
+	Season   string    `bson:"_id"`
+	FrozenAt time.Time `bson:"frozen_at"`
+}
+
+// mergeStandings combines standings across modes into one entry per player,
+// in the order players first appear.
+func mergeStandings(standings []Standing) []LeaderboardEntry {
+	index := make(map[[2]string]int)
+
+	var entries []LeaderboardEntry
+	for _, standing := range standings {
+		key := [2]string{standing.ID, standing.Name}
+
+		i, ok := index[key]
+		if !ok {
+			i = len(entries)
+			index[key] = i
+			entries = append(entries, LeaderboardEntry{
+				ID:    standing.ID,
+				Name:  standing.Name,
+				Stats: make(map[string]ScoreStats),
+			})
+		}
+
+		entries[i].Outcomes = entries[i].Outcomes.add(standing.Outcomes)
+
+		for field, stats := range standing.Stats {
+			entries[i].Stats[field] = entries[i].Stats[field].merge(stats)
+		}
+	}
+
+	return entries
+}
+
+// matchesStanding reports whether a standing belongs on a query's board.
+func matchesStanding(standing Standing, query LeaderboardQuery) bool {
+	return (query.Game == "" || standing.Game == query.Game) &&
+		(query.Mode == "" || standing.Mode == query.Mode)
+}
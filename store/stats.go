@@ -0,0 +1,171 @@
+package store
+
+import (
+	"LeaderboardsBackend/pipeline"
+	"LeaderboardsBackend/util"
+	"context"
+	"sort"
+	"strings"
+)
+
+// StatsStore keeps PlayerStats, each player's totals per game mode rolled up
+// from the event log, so a profile is a lookup instead of a scan over every
+// event the player was part of.
+//
+// Rollups are updated by the event stream worker, the only place that sees
+// every written event, and can be rebuilt from the event log at any time.
+// Reads should only trust them once StatsBuilt reports a complete rebuild;
+// until then the event log is authoritative.
+//
+// Score events whose score_field pipeline.ValidField rejects can't be stored
+// as a document key, so they're never counted: not in rollups, and not in
+// profiles totalled from the event log either, so both agree.
+type StatsStore interface {
+	// UpdatePlayerStats recomputes the rollups of every player, game and mode
+	// in newly written events, so applying the same events twice is harmless.
+	UpdatePlayerStats(ctx context.Context, events []util.MongoResult) error
+	// RebuildPlayerStats recomputes every rollup from the event log and marks
+	// the rollups as built.
+	RebuildPlayerStats(ctx context.Context) error
+	StatsBuilt(ctx context.Context) (bool, error)
+	// PlayerStats returns a player's rollups, optionally limited to a game or
+	// game mode, sorted by game and mode.
+	PlayerStats(ctx context.Context, query ProfileQuery) ([]PlayerStats, error)
+}
+
+// PlayerStats is one player's totals in one game mode: the sum of every score
+// field, plus their kills and deaths. Name is the name they last played as.
+type PlayerStats struct {
+	ID     string           `bson:"_id"`
+	Player string           `bson:"player"`
+	Game   string           `bson:"game"`
+	Mode   string           `bson:"mode"`
+	Name   string           `bson:"name"`
+	Totals map[string]int64 `bson:"totals"`
+}
+
+func statsKey(player, game, mode string) string {
+	return strings.Join([]string{player, game, mode}, "\x00")
+}
+
+// rollupStats totals a batch of events into PlayerStats, in the order players
+// first appear. Kills are credited by creditedKiller, and Score events with
+// an invalid score_field are skipped.
+func rollupStats(events []util.MongoResult) []PlayerStats {
+	index := make(map[string]int)
+
+	var rollups []PlayerStats
+	add := func(event util.MongoResult, player, name, total string, amount int64) {
+		if player == "" {
+			return
+		}
+
+		key := statsKey(player, event.GameID, event.GameModeID)
+		i, ok := index[key]
+		if !ok {
+			i = len(rollups)
+			index[key] = i
+			rollups = append(rollups, PlayerStats{
+				ID:     key,
+				Player: player,
+				Game:   event.GameID,
+				Mode:   event.GameModeID,
+				Totals: make(map[string]int64),
+			})
+		}
+
+		rollups[i].Name = name
+		rollups[i].Totals[total] += amount
+	}
+
+	for _, event := range events {
+		switch event.AnalyticEventType {
+		case "Score":
+			if pipeline.ValidField(event.ScoreField) {
+				add(event, event.PlayerUUID, event.PlayerName, event.ScoreField, int64(event.Value))
+			}
+		case "Death":
+			add(event, event.PlayerUUID, event.PlayerName, VarDeaths, 1)
+			add(event, creditedKiller(event), event.KillerName, VarKills, 1)
+		}
+	}
+
+	return rollups
+}
+
+// matchesStats reports whether a rollup belongs to a profile query.
+func matchesStats(stats PlayerStats, query ProfileQuery) bool {
+	return stats.Player == query.Player &&
+		(query.Game == "" || stats.Game == query.Game) &&
+		(query.Mode == "" || stats.Mode == query.Mode)
+}
+
+// StatsDiscrepancy is a rollup total that differs from the event log.
+type StatsDiscrepancy struct {
+	Player, Game, Mode, Total string
+	Stored, Expected          int64
+}
+
+// CompareStats lists every total where the stored rollups differ from ones
+// recomputed from the event log, sorted by player, game, mode and total.
+func CompareStats(stored, recomputed []PlayerStats) []StatsDiscrepancy {
+	byKey := make(map[string]PlayerStats)
+	for _, stats := range stored {
+		byKey[stats.ID] = stats
+	}
+
+	var found []StatsDiscrepancy
+	check := func(stats PlayerStats, stored, expected map[string]int64) {
+		for total := range union(stored, expected) {
+			if stored[total] != expected[total] {
+				found = append(found, StatsDiscrepancy{
+					Player:   stats.Player,
+					Game:     stats.Game,
+					Mode:     stats.Mode,
+					Total:    total,
+					Stored:   stored[total],
+					Expected: expected[total],
+				})
+			}
+		}
+	}
+
+	for _, expected := range recomputed {
+		check(expected, byKey[expected.ID].Totals, expected.Totals)
+		delete(byKey, expected.ID)
+	}
+
+	// Whatever is left has no events behind it at all.
+	for _, stats := range byKey {
+		check(stats, stats.Totals, nil)
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		a, b := found[i], found[j]
+		if a.Player != b.Player {
+			return a.Player < b.Player
+		}
+		if a.Game != b.Game {
+			return a.Game < b.Game
+		}
+		if a.Mode != b.Mode {
+			return a.Mode < b.Mode
+		}
+
+		return a.Total < b.Total
+	})
+
+	return found
+}
+
+func union(a, b map[string]int64) map[string]bool {
+	keys := make(map[string]bool, len(a)+len(b))
+	for key := range a {
+		keys[key] = true
+	}
+	for key := range b {
+		keys[key] = true
+	}
+
+	return keys
+}
@@ -0,0 +1,144 @@
+package store
+
+import (
+	"LeaderboardsBackend/metric"
+	"LeaderboardsBackend/util"
+	"context"
+)
+
+// EventStore is the read side of the analytics event log. Handlers depend on
+// this instead of a *mongo.Client so the storage backend can be swapped out.
+type EventStore interface {
+	Games(ctx context.Context) ([]GameModes, error)
+	GameList(ctx context.Context, query GameListQuery) ([]GameSummary, error)
+	RecentGames(ctx context.Context, query RecentQuery) ([]GameSummary, error)
+	InstanceEvents(ctx context.Context, id string) ([]util.MongoResult, error)
+	Leaderboard(ctx context.Context, query LeaderboardQuery) (LeaderboardResult, error)
+	// PlayerScores returns every player's score totals for a leaderboard
+	// query, unranked; filters, user and paging are ignored.
+	PlayerScores(ctx context.Context, query LeaderboardQuery) ([]LeaderboardEntry, error)
+	ProfileEvents(ctx context.Context, query ProfileQuery) ([]util.MongoResult, error)
+	// ScoreFields lists the score fields recorded for a game mode, sorted.
+	ScoreFields(ctx context.Context, game, mode string) ([]string, error)
+	FavoriteMode(ctx context.Context, since int64) (FavoriteMode, error)
+}
+
+// EventWriter appends new events to the event log. Events must carry an
+// EventID; an event whose id is already stored is skipped rather than written
+// twice.
+type EventWriter interface {
+	InsertEvents(ctx context.Context, events []util.MongoResult) (InsertResult, error)
+}
+
+// InsertResult reports which events of a batch were written and how many were
+// skipped as duplicates.
+type InsertResult struct {
+	Inserted []util.MongoResult
+	Skipped  int
+}
+
+// Backend is implemented by every storage backend.
+type Backend interface {
+	EventStore
+	EventWriter
+	StandingsStore
+	StatsStore
+	SummaryStore
+}
+
+type GameListQuery struct {
+	Game   string
+	Mode   string
+	Page   int
+	Length int
+}
+
+type RecentQuery struct {
+	Player string
+	Page   int
+	Length int
+}
+
+type ProfileQuery struct {
+	Player string
+	Game   string
+	Mode   string
+}
+
+// LeaderboardQuery describes a single leaderboard page. Filters are score
+// fields, the first of which decides who appears on the board; a "-" prefix
+// sorts that field ascending. From and To bound the time_code of the Score
+// events counted, To being exclusive; zero leaves that side open.
+//
+// User on its own narrows the board to that player's row. With Around set,
+// the page is instead the player's row and up to Around rows either side of
+// it, and TotalCount is the size of the whole board.
+//
+// Ranking and Tiebreak choose how tied players are positioned; see RankOrdinal
+// and TiebreakUUID.
+type LeaderboardQuery struct {
+	Game     string
+	Mode     string
+	Instance string
+	User     string
+	Around   int
+	// Players, when set, limits PlayerScores to these players.
+	Players []string
+	Filters []string
+	// Aggregations maps score fields to how they are aggregated; fields
+	// without one are summed.
+	Aggregations map[string]string
+	// Metrics are derived scores added to every entry under their name.
+	Metrics map[string]*metric.Expression
+	// Outcomes collects kills, deaths, wins and games, which metrics and
+	// MinGames rely on. Players below MinGames are left off the board.
+	Outcomes bool
+	MinGames int
+	Ranking  string
+	Tiebreak string
+	From     int64
+	To       int64
+	Page     int
+	Length   int
+}
+
+type GameModes struct {
+	Game      interface{} `bson:"_id"`
+	GameModes []string    `bson:"gamemodes"`
+}
+
+type GameSummary struct {
+	GameID     string            `bson:"game_id"`
+	GameModeID string            `bson:"game_mode_id"`
+	InstanceID string            `bson:"instance_id"`
+	Winners    map[string]string `bson:"winners"`
+	Losers     map[string]string `bson:"losers"`
+	EndTime    int64             `bson:"end_time"`
+}
+
+type LeaderboardResult struct {
+	Entries    []LeaderboardEntry `bson:"entries"`
+	TotalCount int32              `bson:"total_count"`
+}
+
+// LeaderboardEntry is one player's row. Scores holds the aggregated value of
+// every score field, computed from the Stats the store collected.
+type LeaderboardEntry struct {
+	ID       string                `bson:"uuid"`
+	Name     string                `bson:"name"`
+	Scores   map[string]float64    `bson:"scores"`
+	Position int32                 `bson:"position"`
+	Stats    map[string]ScoreStats `bson:"stats" json:"-"`
+	Outcomes Outcomes              `bson:"outcomes" json:"-"`
+}
+
+type FavoriteMode struct {
+	ID    string `bson:"_id"`
+	Count int32  `bson:"count"`
+}
+
+// Offset converts a one-based page and a page length into the number of
+// entries to skip.
+func Offset(page, length int) int {
+	return (page * length) - length
+}
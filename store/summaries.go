@@ -0,0 +1,43 @@
+package store
+
+import (
+	"LeaderboardsBackend/util"
+	"context"
+	"sort"
+)
+
+// SummaryStore keeps GameSummaries, one document per finished game, so game
+// lists and a player's recent games are an indexed lookup instead of a group
+// over every Finish event.
+//
+// Like PlayerStats, summaries are updated by the event stream worker and can
+// be rebuilt from the event log at any time. Reads should only trust them
+// once SummariesBuilt reports a complete rebuild.
+type SummaryStore interface {
+	// UpdateGameSummaries stores the summaries of the games finished in
+	// newly written events. Storing a summary again replaces it.
+	UpdateGameSummaries(ctx context.Context, events []util.MongoResult) error
+	// RebuildGameSummaries recomputes every summary from the event log and
+	// marks the summaries as built.
+	RebuildGameSummaries(ctx context.Context) error
+	SummariesBuilt(ctx context.Context) (bool, error)
+	// SummaryGameList and SummaryRecentGames answer GameList and RecentGames
+	// from the summaries.
+	SummaryGameList(ctx context.Context, query GameListQuery) ([]GameSummary, error)
+	SummaryRecentGames(ctx context.Context, query RecentQuery) ([]GameSummary, error)
+}
+
+// summaryPlayers lists everyone who won or lost a game, sorted.
+func summaryPlayers(summary GameSummary) []string {
+	players := make([]string, 0, len(summary.Winners)+len(summary.Losers))
+	for player := range summary.Winners {
+		players = append(players, player)
+	}
+	for player := range summary.Losers {
+		players = append(players, player)
+	}
+
+	sort.Strings(players)
+
+	return players
+}
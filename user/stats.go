@@ -0,0 +1,37 @@
+package user
+
+import (
+	"LeaderboardsBackend/util"
+	"context"
+	"log"
+)
+
+// UpdateStats is a hook bringing the player rollups up to date with newly
+// written events.
+func UpdateStats(events []util.MongoResult) error {
+	return Store.UpdatePlayerStats(context.Background(), events)
+}
+
+// EnsureStats builds the player rollups unless they have been built before,
+// so profiles switch over to them once they are complete.
+func EnsureStats() {
+	built, err := Store.StatsBuilt(context.Background())
+	if err != nil {
+		log.Println("Failed to check player stats: ", err)
+		return
+	}
+
+	if !built {
+		RebuildStats()
+	}
+}
+
+// RebuildStats recomputes the player rollups from the event log.
+func RebuildStats() {
+	if err := Store.RebuildPlayerStats(context.Background()); err != nil {
+		log.Println("Failed to rebuild player stats: ", err)
+		return
+	}
+
+	log.Println("Rebuilt player stats.")
+}
@@ -1,15 +1,14 @@
 package user
 
 import (
+	"LeaderboardsBackend/pipeline"
+	"LeaderboardsBackend/store"
 	"LeaderboardsBackend/util"
 	json2 "encoding/json"
-	"fmt"
 	"github.com/gin-gonic/gin"
-	"github.com/simagix/keyhole/mdb"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"log"
 	"strconv"
+	"time"
 )
 
 type userRequest struct {
@@ -23,38 +22,40 @@ type gameModeUserResponse struct {
 	EventTotals map[string]map[string]map[string]int64
 }
 
-type recentGameBson struct {
-	GameID     string            `bson:"game_id"`
-	GameModeID string            `bson:"game_mode_id"`
-	InstanceID string            `bson:"instance_id"`
-	Winners    map[string]string `bson:"winners"`
-	Losers     map[string]string `bson:"losers"`
-	EndTime    int64             `bson:"end_time"`
-}
+var Store store.Backend
+
+// Rollups is set when the event stream worker keeps the player rollups and
+// game summaries in step with the Events collection. Without it, events
+// written by other producers never reach them, so profiles and recent games
+// are computed from events.
+var Rollups bool
 
-var Client *mongo.Client
+// Players check their recent games right after finishing one.
+var (
+	recentPolicy  = util.CachePolicy{TTL: 15 * time.Second}
+	profilePolicy = util.CachePolicy{TTL: time.Minute, Stale: 5 * time.Minute, Refresh: true}
+)
 
-func Register(r *gin.Engine, client *mongo.Client) {
-	Client = client
+func Register(r *gin.Engine, events store.Backend, rollups bool) {
+	Store = events
+	Rollups = rollups
 
-	util.CachedGET(r, "/user", userHandler)
-	util.CachedGET(r, "/user/recent/:id", recentHandler)
-	util.CachedGET(r, "/user/profile/:id", userHandler)
-	util.CachedGET(r, "/user/profile/:id/:game", userHandler)
-	util.CachedGET(r, "/user/profile/:id/:game/:mode", userHandler)
+	util.CachedGETWithPolicy(r, "/user", profilePolicy, userHandler)
+	util.CachedGETWithPolicy(r, "/user/recent/:id", recentPolicy, recentHandler)
+	util.CachedGETWithPolicy(r, "/user/profile/:id", profilePolicy, userHandler)
+	util.CachedGETWithPolicy(r, "/user/profile/:id/:game", profilePolicy, userHandler)
+	util.CachedGETWithPolicy(r, "/user/profile/:id/:game/:mode", profilePolicy, userHandler)
 }
 
 func recentHandler(c *gin.Context) []byte {
-	var err error
-	var collection *mongo.Collection
-	var cur *mongo.Cursor
-
 	var request userRequest
 	if err := c.ShouldBindUri(&request); err != nil {
 		c.JSON(400, gin.H{"error": err})
 		return nil
 	}
 
+	util.Tag(c, util.PlayerTag(request.ID))
+
 	page, err := strconv.Atoi(c.Query("page"))
 	if err != nil {
 		page = 1
@@ -65,105 +66,28 @@ func recentHandler(c *gin.Context) []byte {
 		length = 100
 	}
 
-	pipeline := `
-	[
-        { 
-            "$match" : {
-                "analytic_event_type" : "Finish"
-            }
-        }, 
-        { 
-            "$project" : {
-                "game_id" : "$game_id", 
-                "game_mode_id" : "$game_mode_id", 
-                "instance_id" : "$instance_id", 
-                "winners" : "$winners", 
-                "losers" : "$losers", 
-                "end_time" : "$time_code"
-            }
-        }, 
-        { 
-            "$group" : {
-                "_id" : {
-                    "player" : {
-                        "$or" : [
-                            {
-                                "$gt" : [
-                                    "$winners.%s", 
-                                    null
-                                ]
-                            }, 
-                            {
-                                "$gt" : [
-                                    "$losers.%s", 
-                                    null
-                                ]
-                            }
-                        ]
-                    }, 
-                    "game_id" : "$game_id", 
-                    "game_mode_id" : "$game_mode_id", 
-                    "instance_id" : "$instance_id", 
-                    "winners" : "$winners", 
-                    "losers" : "$losers", 
-                    "end_time" : "$end_time"
-                }
-            }
-        }, 
-        { 
-            "$match" : {
-                "_id.player" : true
-            }
-        }, 
-        { 
-            "$replaceRoot" : {
-                "newRoot" : "$_id"
-            }
-        }, 
-        { 
-            "$project" : {
-                "game_id" : 1.0, 
-                "game_mode_id" : 1.0, 
-                "instance_id" : 1.0, 
-                "winners" : 1.0, 
-                "losers" : 1.0,
-				"end_time": 1.0
-            }
-        },
-		{
-			"$sort": {
-				"end_time": -1.0
-			}
-		},
-		{
-			"$skip": %d
-		},
-		{
-			"$limit": %d
-		}
-    ]`
-
-	pipeline = fmt.Sprintf(pipeline, request.ID, request.ID, (page*length)-length, length)
-
-	collection = Client.Database("Analytics").Collection("Events")
-	opts := options.Aggregate()
-	opts.SetAllowDiskUse(true)
-	if cur, err = collection.Aggregate(c, mdb.MongoPipeline(pipeline), opts); err != nil {
-		c.JSON(500, gin.H{"err": err})
-		return nil
+	query := store.RecentQuery{
+		Player: request.ID,
+		Page:   page,
+		Length: length,
 	}
 
-	var results [] recentGameBson
-	for cur.Next(c) {
-		var result recentGameBson
-
-		err := cur.Decode(&result)
+	recent := Store.RecentGames
+	if Rollups {
+		built, err := Store.SummariesBuilt(c)
 		if err != nil {
-			c.JSON(500, gin.H{"err": err})
-			return nil
+			log.Println("Failed to check game summaries... grouping events instead. ", err)
+		}
+
+		if built {
+			recent = Store.SummaryRecentGames
 		}
+	}
 
-		results = append(results, result)
+	results, err := recent(c, query)
+	if err != nil {
+		c.JSON(500, gin.H{"err": err.Error()})
+		return nil
 	}
 
 	json, err := json2.MarshalIndent(results, "", "    ")
@@ -182,65 +106,45 @@ func userHandler(c *gin.Context) []byte {
 		return nil
 	}
 
-	pipeline := `
-	[
-        { 
-            "$match" : {
-                "$or" : [
-                    {
-                        "player_uuid" : "%s"
-                    }, 
-                    {
-                        "killer_uuid" : "%s"
-                    }
-                ]
-				%s
-            }
-        }, 
-        { 
-            "$project" : {
-                "game_id" : 1.0, 
-                "game_mode_id" : 1.0, 
-                "server_event_type" : 1.0, 
-                "world_name" : 1.0, 
-                "analytic_event_type" : 1.0, 
-                "from" : 1.0, 
-                "to" : 1.0, 
-                "value" : 1.0, 
-                "score_field" : 1.0, 
-                "player_name" : 1.0, 
-                "player_uuid" : 1.0, 
-                "death_event_type" : 1.0, 
-                "death_details" : 1.0, 
-                "killer_name" : 1.0, 
-                "killer_uuid" : 1.0, 
-                "winners" : 1.0, 
-                "losers" : 1.0, 
-                "finish_event_type" : 1.0
-            }
-        }
-    ]`
-
 	if request.ID == "" {
 		c.JSON(400, gin.H{"error": "No user provided"})
 		return nil;
 	}
 
-	matchGame := ``
+	util.Tag(c, util.PlayerTag(request.ID))
 
-	if (request.Game != "") {
-		matchGame += ", \"game_id\" : \"" + request.Game + "\""
+	query := store.ProfileQuery{
+		Player: request.ID,
+		Game:   request.Game,
+		Mode:   request.Mode,
 	}
 
-	if request.Mode != "" {
-		matchGame += ", \"game_mode_id\" : \"" + request.Mode + "\""
+	if Rollups {
+		built, err := Store.StatsBuilt(c)
+		if err != nil {
+			log.Println("Failed to check player stats... totalling events instead. ", err)
+		}
+
+		if built {
+			return statsProfile(c, query)
+		}
 	}
 
-	pipeline = fmt.Sprintf(pipeline, request.ID, request.ID, matchGame)
+	events, err := Store.ProfileEvents(c, query)
+	if err != nil {
+		c.JSON(500, gin.H{"err": err.Error()})
+		return nil
+	}
 
 	var gameModeUserResponse gameModeUserResponse
 	gameModeUserResponse.EventTotals = make(map[string]map[string]map[string]int64)
-	util.RunPipelineOnEvents(pipeline, Client, c, func(result util.MongoResult) {
+	for _, result := range events {
+		// Like the rollups, ignore score fields that can't be stored as a
+		// document key, so a profile doesn't change once the rollups are built.
+		if result.AnalyticEventType == "Score" && !pipeline.ValidField(result.ScoreField) {
+			continue
+		}
+
 		if gameModeUserResponse.Name == "" {
 			if result.PlayerUUID == request.ID {
 				gameModeUserResponse.Name = result.PlayerName
@@ -260,6 +164,13 @@ func userHandler(c *gin.Context) []byte {
 
 				gameModeUserResponse.EventTotals[result.GameID][result.GameModeID]["deaths"]++
 			} else {
+				if (gameModeUserResponse.EventTotals[result.GameID] == nil) {
+					gameModeUserResponse.EventTotals[result.GameID] = make(map[string]map[string]int64)
+				}
+				if (gameModeUserResponse.EventTotals[result.GameID][result.GameModeID] == nil) {
+					gameModeUserResponse.EventTotals[result.GameID][result.GameModeID] = make(map[string]int64)
+				}
+
 				gameModeUserResponse.EventTotals[result.GameID][result.GameModeID]["kills"]++
 			}
 		}
@@ -273,7 +184,7 @@ func userHandler(c *gin.Context) []byte {
 			}
 			gameModeUserResponse.EventTotals[result.GameID][result.GameModeID][result.ScoreField] += int64(result.Value)
 		}
-	})
+	}
 
 	json, err := json2.MarshalIndent(gameModeUserResponse, "", "    ")
 	if err != nil {
@@ -282,3 +193,33 @@ func userHandler(c *gin.Context) []byte {
 	}
 	return json
 }
+
+// statsProfile answers a profile from the player's rollups.
+func statsProfile(c *gin.Context, query store.ProfileQuery) []byte {
+	rollups, err := Store.PlayerStats(c, query)
+	if err != nil {
+		c.JSON(500, gin.H{"err": err.Error()})
+		return nil
+	}
+
+	var response gameModeUserResponse
+	response.EventTotals = make(map[string]map[string]map[string]int64)
+	for _, stats := range rollups {
+		if response.Name == "" {
+			response.Name = stats.Name
+		}
+
+		if response.EventTotals[stats.Game] == nil {
+			response.EventTotals[stats.Game] = make(map[string]map[string]int64)
+		}
+
+		response.EventTotals[stats.Game][stats.Mode] = stats.Totals
+	}
+
+	json, err := json2.MarshalIndent(response, "", "    ")
+	if err != nil {
+		c.JSON(500, gin.H{"err": err})
+		return nil
+	}
+	return json
+}
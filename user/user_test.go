@@ -0,0 +1,66 @@
+package user
+
+import (
+	"LeaderboardsBackend/store"
+	"LeaderboardsBackend/util"
+	"context"
+	"github.com/gin-gonic/gin"
+	"net/http/httptest"
+	"testing"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+const alice = "6f1d9a43-53a0-4c52-9c4c-1b3f0c1e2a11"
+
+// profile runs userHandler for alice's profile against events.
+func profile(t *testing.T, events *store.MemoryStore) string {
+	Store = events
+	Rollups = true
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/user/profile/"+alice, nil)
+	c.Params = gin.Params{{Key: "id", Value: alice}}
+
+	body := userHandler(c)
+	if body == nil {
+		t.Fatalf("got no profile, status %d", c.Writer.Status())
+	}
+
+	return string(body)
+}
+
+func TestProfileSkipsInvalidScoreFields(t *testing.T) {
+	score := func(field string, value int32) util.MongoResult {
+		return util.MongoResult{
+			TimeCode:          1000,
+			GameID:            "arena",
+			GameModeID:        "solo",
+			InstanceID:        "1",
+			AnalyticEventType: "Score",
+			ScoreField:        field,
+			Value:             value,
+			PlayerName:        "alice",
+			PlayerUUID:        alice,
+		}
+	}
+
+	events := store.NewMemoryStore([]util.MongoResult{
+		score("kills", 3),
+		score("$where", 1),
+		score("scores.kills", 2),
+	})
+
+	fromEvents := profile(t, events)
+
+	if err := events.RebuildPlayerStats(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	fromRollups := profile(t, events)
+
+	if fromEvents != fromRollups {
+		t.Errorf("profiles differ once the rollups are built:\n%s\n%s", fromEvents, fromRollups)
+	}
+}
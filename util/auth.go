@@ -0,0 +1,28 @@
+package util
+
+import (
+	"crypto/subtle"
+	"github.com/gin-gonic/gin"
+	"os"
+)
+
+// RequireToken guards a route with a bearer token read from the named
+// environment variable. When the variable is unset the route answers 503,
+// unless ALLOW_UNAUTHENTICATED is set to leave it open for local development.
+func RequireToken(variable string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := os.Getenv(variable)
+		if token == "" {
+			if os.Getenv("ALLOW_UNAUTHENTICATED") == "" {
+				c.AbortWithStatusJSON(503, gin.H{"error": variable + " is not configured"})
+			}
+
+			return
+		}
+
+		given := []byte(c.GetHeader("Authorization"))
+		if subtle.ConstantTimeCompare(given, []byte("Bearer "+token)) != 1 {
+			c.AbortWithStatusJSON(401, gin.H{"error": "Unauthorized"})
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package util
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/go-redis/redis"
+	"strings"
+	"time"
+)
+
+var errCacheMiss = errors.New("cache miss")
+
+// cacheBackend stores CachedGET responses along with the tags they were
+// built from.
+type cacheBackend interface {
+	// Get returns errCacheMiss when the key isn't cached.
+	Get(key string) ([]byte, error)
+	// Set stores an entry recorded under the given tags.
+	Set(key string, value []byte, tags []string, ttl time.Duration) error
+	// Invalidate removes every entry recorded under the tags and returns how
+	// many entries were removed.
+	Invalidate(tags []string) (int, error)
+	// Tags lists the known tags starting with prefix.
+	Tags(prefix string) ([]string, error)
+}
+
+type redisCache struct {
+	client *redis.Client
+}
+
+func tagKey(tag string) string {
+	return "leaderboards.tag." + tag
+}
+
+func (r *redisCache) Get(key string) ([]byte, error) {
+	value, err := r.client.Get(key).Bytes()
+	if err == redis.Nil {
+		return nil, errCacheMiss
+	}
+
+	return value, err
+}
+
+// setScript stores an entry and adds its key to the set of every tag at once,
+// so an invalidation can never see the entry without its tags. KEYS are the
+// entry and then its tag sets; ARGV the value and the lifetime in ms, 0 for
+// none. Routes with different lifetimes share tags, so a tag set's expiry is
+// only ever extended, to outlive the longest-lived entry it points at.
+var setScript = redis.NewScript(`
+local ttl = tonumber(ARGV[2])
+for i = 2, #KEYS do
+	-- A set without an expiry is new, or points at an entry that never expires.
+	local remaining = redis.call("PTTL", KEYS[i])
+	redis.call("SADD", KEYS[i], KEYS[1])
+
+	if ttl == 0 then
+		redis.call("PERSIST", KEYS[i])
+	elseif remaining == -2 or remaining >= 0 and remaining < ttl then
+		redis.call("PEXPIRE", KEYS[i], ttl)
+	end
+end
+
+if ttl == 0 then
+	return redis.call("SET", KEYS[1], ARGV[1])
+end
+return redis.call("SET", KEYS[1], ARGV[1], "PX", ttl)
+`)
+
+func (r *redisCache) Set(key string, value []byte, tags []string, ttl time.Duration) error {
+	keys := []string{key}
+	for _, tag := range tags {
+		keys = append(keys, tagKey(tag))
+	}
+
+	return setScript.Run(r.client, keys, value, int64(ttl/time.Millisecond)).Err()
+}
+
+func (r *redisCache) Invalidate(tags []string) (int, error) {
+	removed := 0
+	for _, tag := range tags {
+		keys, err := r.client.SMembers(tagKey(tag)).Result()
+		if err != nil {
+			return removed, err
+		}
+
+		if len(keys) == 0 {
+			continue
+		}
+
+		keys = append(keys, tagKey(tag))
+		n, err := r.client.Del(keys...).Result()
+		if err != nil {
+			return removed, err
+		}
+
+		removed += int(n) - 1
+	}
+
+	return removed, nil
+}
+
+// Tags walks the tag sets with SCAN rather than KEYS, which would block
+// Redis while it went through every key.
+func (r *redisCache) Tags(prefix string) ([]string, error) {
+	var tags []string
+
+	iter := r.client.Scan(0, globEscape(tagKey(prefix))+"*", 1000).Iterator()
+	for iter.Next() {
+		tags = append(tags, strings.TrimPrefix(iter.Val(), tagKey("")))
+	}
+
+	return tags, iter.Err()
+}
+
+// globEscape escapes the characters SCAN's MATCH pattern treats specially.
+func globEscape(value string) string {
+	var escaped strings.Builder
+	for _, c := range value {
+		if strings.ContainsRune(`*?[]\`, c) {
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(c)
+	}
+
+	return escaped.String()
+}
+
+// unlockScript deletes a lock only if we still hold it, so a lock that
+// expired and was taken by another replica isn't released by mistake.
+var unlockScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0`)
+
+func lockKey(key string) string {
+	return "leaderboards.lock." + key
+}
+
+// Lock tries to take a short-lived lock on key, returning the token needed
+// to release it.
+func (r *redisCache) Lock(key string, ttl time.Duration) (string, bool, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", false, err
+	}
+
+	token := hex.EncodeToString(buf)
+	ok, err := r.client.SetNX(lockKey(key), token, ttl).Result()
+
+	return token, ok, err
+}
+
+func (r *redisCache) Unlock(key, token string) error {
+	return unlockScript.Run(r.client, []string{lockKey(key)}, token).Err()
+}
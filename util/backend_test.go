@@ -0,0 +1,82 @@
+package util
+
+import (
+	"github.com/go-redis/redis"
+	"os"
+	"testing"
+	"time"
+)
+
+// testRedis connects to the Redis server in REDIS_TEST_ADDR, skipping the
+// test when it isn't set. The server is flushed before and after.
+func testRedis(t *testing.T) (*redisCache, func()) {
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR is not set")
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	if err := client.FlushAll().Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return &redisCache{client: client}, func() {
+		client.FlushAll()
+		client.Close()
+	}
+}
+
+func TestRedisTagsOutliveEveryEntry(t *testing.T) {
+	cache, done := testRedis(t)
+	defer done()
+
+	tags := []string{"mode.arena.solo"}
+	if err := cache.Set("fields", []byte("1"), tags, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Set("list", []byte("2"), tags, 6*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	// The shorter-lived list mustn't shorten the tag below the fields entry.
+	remaining, err := cache.client.PTTL(tagKey(tags[0])).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining < 59*time.Minute {
+		t.Errorf("tag expires in %v, before the hour-long entry", remaining)
+	}
+
+	removed, err := cache.Invalidate(tags)
+	if err != nil || removed != 2 {
+		t.Errorf("got %d, %v, want both entries invalidated", removed, err)
+	}
+
+	if _, err := cache.Get("fields"); err != errCacheMiss {
+		t.Errorf("fields: got %v, want it invalidated", err)
+	}
+}
+
+func TestRedisSetExpires(t *testing.T) {
+	cache, done := testRedis(t)
+	defer done()
+
+	if err := cache.Set("list", []byte("1"), []string{"games"}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"list", tagKey("games")} {
+		remaining, err := cache.client.PTTL(key).Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if remaining <= 0 || remaining > time.Minute {
+			t.Errorf("%s expires in %v, want within a minute", key, remaining)
+		}
+	}
+
+	value, err := cache.Get("list")
+	if err != nil || string(value) != "1" {
+		t.Errorf("got %q, %v", value, err)
+	}
+}
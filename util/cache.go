@@ -1,70 +1,129 @@
 package util
 
 import (
-	"fmt"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis"
 	"log"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 )
 
-var client *redis.Client
+// healthInterval is how often Redis is pinged to decide which tier serves.
+const healthInterval = 10 * time.Second
+
+var cache *tieredCache
 
 func SetupCache() {
-	client = redis.NewClient(&redis.Options{
+	client := redis.NewClient(&redis.Options{
 		Addr:     os.Getenv("REDIS"),
 		Password: "",
 		DB:       0,
 	})
 
-	_, err := client.Ping().Result()
+	size, err := strconv.Atoi(os.Getenv("CACHE_LRU_SIZE"))
+	if err != nil || size <= 0 {
+		size = 1024
+	}
+
+	cache = &tieredCache{
+		redis:   &redisCache{client: client},
+		memory:  newLRUCache(size),
+		pending: make(map[string]bool),
+	}
+
+	_, err = client.Ping().Result()
 	if err != nil {
-		log.Println("Failed to connect to redis... caching is offline. ", err)
+		log.Println("Failed to connect to redis... caching in memory until it recovers. ", err)
+	} else {
+		cache.online = true
 	}
+
+	go cache.monitor(healthInterval)
 }
 
 func CachedGET(r *gin.Engine, path string, action func(*gin.Context) []byte) {
+	CachedGETWithPolicy(r, path, DefaultPolicy, action)
+}
+
+// CachedGETWithPolicy registers a cached GET route whose entries live as long
+// as the policy allows.
+func CachedGETWithPolicy(r *gin.Engine, path string, policy CachePolicy, action func(*gin.Context) []byte) {
 	r.GET(path, func(c *gin.Context) {
 		defer handlePanic()
 
-		key := "leaderboards" + strings.Replace(path, "/", ".", -1) + "."
-		for i, param := range c.Params {
-			key += param.Value
-			if i+1 != len(c.Params) {
-				key += "."
-			}
-		}
+		key := cacheKey(path, c)
+		c.Header("X-Cache-Key", key)
 
-		i := 0;
-		for k, v := range c.Request.URL.Query() {
-			key += fmt.Sprint(".", k, ".")
-			for _, val := range v {
-				key += val
-			}
+		val, err := cache.Get(key)
+		if err == nil {
+			var entry cacheEntry
+			if json.Unmarshal(val, &entry) == nil {
+				if policy.fresh(entry) {
+					c.Header("X-Cache", "HIT")
+					entry.serve(c, policy)
 
-			i++;
-		}
+					return
+				}
+
+				if policy.Refresh {
+					c.Header("X-Cache", "STALE")
+					go refresh(key, c.Copy(), policy, action)
+					entry.serve(c, policy)
 
-		val, err := client.Get(key).Result()
-		if err != nil {
-			response := action(c)
-			if err == redis.Nil {
-				err := client.Set(key, string(response), 5 * time.Minute).Err()
-				if err != nil {
-					log.Println("Failed to insert into cache: ", err)
+					return
 				}
-			}
-			fmt.Fprint(c.Writer, string(response))
 
-			return
+				fresh := coalesce(key, c, policy, action)
+				if fresh.Status >= 500 {
+					c.Header("X-Cache", "STALE")
+					entry.serve(c, policy)
+
+					return
+				}
+
+				c.Header("X-Cache", "MISS")
+				fresh.serve(c, policy)
+
+				return
+			}
 		}
 
-		fmt.Fprint(c.Writer, val)
+		c.Header("X-Cache", "MISS")
+		coalesce(key, c, policy, action).serve(c, policy)
 	})
 }
 
+// maxKeyLength is the longest cache key stored verbatim; longer keys are
+// replaced by a hash so huge query strings can't bloat Redis.
+const maxKeyLength = 200
+
+// cacheKey canonicalises a request into a cache key. Route params keep their
+// order and are escaped so a "." inside a value can't shift them, and query
+// params are sorted so the same request always maps to the same key.
+func cacheKey(path string, c *gin.Context) string {
+	key := "leaderboards" + strings.Replace(path, "/", ".", -1)
+	for _, param := range c.Params {
+		key += "." + strings.Replace(url.PathEscape(param.Value), ".", "%2E", -1)
+	}
+
+	if query := c.Request.URL.Query(); len(query) > 0 {
+		key += "?" + query.Encode()
+	}
+
+	if len(key) > maxKeyLength {
+		sum := sha256.Sum256([]byte(key))
+		key = "leaderboards.hash." + hex.EncodeToString(sum[:])
+	}
+
+	return key
+}
+
 func handlePanic() {
 
 }
@@ -0,0 +1,77 @@
+package util
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// keyFor builds the cache key of a request to target on a route with params.
+func keyFor(path, target string, params ...string) string {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", target, nil)
+	for i := 0; i+1 < len(params); i += 2 {
+		c.Params = append(c.Params, gin.Param{Key: params[i], Value: params[i+1]})
+	}
+
+	return cacheKey(path, c)
+}
+
+func TestCacheKeySortsQuery(t *testing.T) {
+	a := keyFor("/leaderboard/:game", "/leaderboard/arena?page=2&length=10", "game", "arena")
+	b := keyFor("/leaderboard/:game", "/leaderboard/arena?length=10&page=2", "game", "arena")
+	if a != b {
+		t.Errorf("reordered query params gave %q and %q", a, b)
+	}
+
+	if want := "leaderboards.leaderboard.:game.arena?length=10&page=2"; a != want {
+		t.Errorf("got %q, want %q", a, want)
+	}
+}
+
+func TestCacheKeyEscapesParams(t *testing.T) {
+	a := keyFor("/leaderboard/:game/:mode", "/leaderboard/a.b/c", "game", "a.b", "mode", "c")
+	b := keyFor("/leaderboard/:game/:mode", "/leaderboard/a/b.c", "game", "a", "mode", "b.c")
+	if a == b {
+		t.Errorf("different params shared the key %q", a)
+	}
+
+	c := keyFor("/leaderboard/:game/:mode", "/leaderboard/solo/arena", "game", "solo", "mode", "arena")
+	d := keyFor("/leaderboard/:game/:mode", "/leaderboard/arena/solo", "game", "arena", "mode", "solo")
+	if c == d {
+		t.Errorf("swapped params shared the key %q", c)
+	}
+}
+
+func TestCacheKeyHashesLongKeys(t *testing.T) {
+	key := keyFor("/leaderboard/:game", "/leaderboard/arena?filter="+strings.Repeat("x", 500), "game", "arena")
+	if !strings.HasPrefix(key, "leaderboards.hash.") || len(key) > maxKeyLength {
+		t.Errorf("got %q, want a hashed key", key)
+	}
+
+	other := keyFor("/leaderboard/:game", "/leaderboard/arena?filter="+strings.Repeat("y", 500), "game", "arena")
+	if key == other {
+		t.Error("different long keys hashed to the same key")
+	}
+}
+
+func TestCachedGETHeaders(t *testing.T) {
+	useMemoryCache()
+
+	r := gin.New()
+	CachedGET(r, "/games/:game", func(c *gin.Context) []byte {
+		return []byte(`{}`)
+	})
+
+	first := get(r, "/games/arena?b=2&a=1")
+	second := get(r, "/games/arena?a=1&b=2")
+
+	if first.Header().Get("X-Cache") != "MISS" || second.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("got X-Cache %q then %q, want MISS then HIT", first.Header().Get("X-Cache"), second.Header().Get("X-Cache"))
+	}
+
+	if key := first.Header().Get("X-Cache-Key"); key == "" || key != second.Header().Get("X-Cache-Key") {
+		t.Errorf("got X-Cache-Key %q then %q", key, second.Header().Get("X-Cache-Key"))
+	}
+}
@@ -0,0 +1,123 @@
+package util
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/singleflight"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	// lockTTL bounds how long a crashed replica can hold a key's lock.
+	lockTTL = 30 * time.Second
+	// lockWait is how long a replica waits on another's computation before
+	// giving up and computing the response itself.
+	lockWait = 10 * time.Second
+	lockPoll = 100 * time.Millisecond
+)
+
+var inflight singleflight.Group
+
+// coalesce computes a missing cache entry once per key no matter how many
+// requests for it arrive at the same time. With CACHE_DISTRIBUTED_LOCK set,
+// replicas also take a Redis lock so only one of them hits Mongo; the others
+// wait for the result to appear in the cache.
+func coalesce(key string, c *gin.Context, policy CachePolicy, action func(*gin.Context) []byte) cacheEntry {
+	value, _, _ := inflight.Do(key, func() (result interface{}, err error) {
+		// The pinned singleflight only forgets a key once its function
+		// returns, so a panicking handler would leave every later request
+		// for the key waiting forever.
+		defer func() {
+			if r := recover(); r != nil {
+				log.Println("Failed to compute cache entry: ", r)
+				result = failedEntry()
+			}
+		}()
+
+		if os.Getenv("CACHE_DISTRIBUTED_LOCK") != "" && cache.backend() == cache.redis {
+			previous, _ := cachedEntry(key)
+
+			token, acquired, err := cache.redis.Lock(key, lockTTL)
+			if err == nil && !acquired {
+				if entry, ok := awaitEntry(key, previous); ok {
+					return entry, nil
+				}
+			}
+
+			if acquired {
+				defer func() {
+					if err := cache.redis.Unlock(key, token); err != nil {
+						log.Println("Failed to release cache lock: ", err)
+					}
+				}()
+			}
+		}
+
+		entry := capture(c, action)
+		if entry.cacheable() {
+			if err := cache.Set(key, entry.marshal(), c.GetStringSlice(tagsKey), policy.lifetime()); err != nil {
+				log.Println("Failed to insert into cache: ", err)
+			}
+		}
+
+		return entry, nil
+	})
+
+	return value.(cacheEntry)
+}
+
+// failedEntry stands in for the response of a handler that panicked.
+func failedEntry() cacheEntry {
+	return cacheEntry{
+		Status:      http.StatusInternalServerError,
+		ContentType: jsonContentType,
+		Body:        []byte(`{"error":"Internal server error"}`),
+		Created:     time.Now(),
+	}
+}
+
+// refresh recomputes a stale entry in the background. It runs outside gin's
+// recovery middleware, which is fine since coalesce recovers from panics.
+func refresh(key string, c *gin.Context, policy CachePolicy, action func(*gin.Context) []byte) {
+	coalesce(key, c, policy, action)
+}
+
+// awaitEntry polls the cache while another replica holds the lock on key,
+// until that replica stores an entry newer than previous, the entry that was
+// cached when the lock was found taken. Comparing against it rather than the
+// local clock keeps replicas with skewed clocks from accepting the stale
+// entry again.
+func awaitEntry(key string, previous cacheEntry) (cacheEntry, bool) {
+	deadline := time.Now().Add(lockWait)
+	for time.Now().Before(deadline) {
+		time.Sleep(lockPoll)
+
+		entry, err := cachedEntry(key)
+		if err == nil && entry.Created.After(previous.Created) {
+			return entry, true
+		}
+
+		if err != nil && err != errCacheMiss {
+			break
+		}
+	}
+
+	return cacheEntry{}, false
+}
+
+// cachedEntry reads and decodes the entry stored under key.
+func cachedEntry(key string) (cacheEntry, error) {
+	var entry cacheEntry
+
+	value, err := cache.Get(key)
+	if err != nil {
+		return entry, err
+	}
+
+	err = json.Unmarshal(value, &entry)
+
+	return entry, err
+}
@@ -0,0 +1,70 @@
+package util
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// useMemoryCache points CachedGET at an empty in-process cache, as if Redis
+// were down.
+func useMemoryCache() {
+	cache = &tieredCache{
+		redis:   &redisCache{client: redis.NewClient(&redis.Options{Addr: "127.0.0.1:0"})},
+		memory:  newLRUCache(100),
+		pending: make(map[string]bool),
+	}
+}
+
+// get sends a GET request with the given headers to r.
+func get(r *gin.Engine, target string, headers ...string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest("GET", target, nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		request.Header.Set(headers[i], headers[i+1])
+	}
+
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, request)
+
+	return recorder
+}
+
+func TestCoalesceRecoversPanics(t *testing.T) {
+	useMemoryCache()
+
+	calls := 0
+	r := gin.New()
+	CachedGET(r, "/panic", func(c *gin.Context) []byte {
+		calls++
+		c.Header("X-Handler", "partial")
+		panic("boom")
+	})
+
+	for i := 0; i < 2; i++ {
+		response := get(r, "/panic")
+
+		if response.Code != http.StatusInternalServerError {
+			t.Errorf("got status %d, want 500", response.Code)
+		}
+		if got := response.Header().Get("Content-Type"); !strings.HasPrefix(got, "application/json") {
+			t.Errorf("got content type %q, want JSON", got)
+		}
+		if !strings.Contains(response.Body.String(), "Internal server error") {
+			t.Errorf("got body %q, want the error", response.Body.String())
+		}
+		if response.Header().Get("X-Handler") != "" {
+			t.Error("headers the handler set before panicking leaked into the response")
+		}
+	}
+
+	if calls != 2 {
+		t.Errorf("handler ran %d times, want 2 since failures aren't cached", calls)
+	}
+}
@@ -0,0 +1,130 @@
+package util
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lruCache is a bounded in-process cache used while Redis is unreachable.
+// Once it holds size entries the least recently used one is evicted.
+type lruCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+	tags    map[string]map[string]bool
+}
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+	tags    []string
+}
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+		tags:    make(map[string]map[string]bool),
+	}
+}
+
+func (l *lruCache) Get(key string) ([]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	element, ok := l.entries[key]
+	if !ok {
+		return nil, errCacheMiss
+	}
+
+	entry := element.Value.(*lruEntry)
+	if time.Now().After(entry.expires) {
+		l.remove(element)
+		return nil, errCacheMiss
+	}
+
+	l.order.MoveToFront(element)
+
+	return entry.value, nil
+}
+
+func (l *lruCache) Set(key string, value []byte, tags []string, ttl time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if element, ok := l.entries[key]; ok {
+		l.remove(element)
+	}
+
+	l.entries[key] = l.order.PushFront(&lruEntry{
+		key:     key,
+		value:   value,
+		expires: time.Now().Add(ttl),
+		tags:    tags,
+	})
+
+	for _, tag := range tags {
+		if l.tags[tag] == nil {
+			l.tags[tag] = make(map[string]bool)
+		}
+
+		l.tags[tag][key] = true
+	}
+
+	for l.order.Len() > l.size {
+		l.remove(l.order.Back())
+	}
+
+	return nil
+}
+
+func (l *lruCache) Invalidate(tags []string) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	removed := 0
+	for _, tag := range tags {
+		for key := range l.tags[tag] {
+			if element, ok := l.entries[key]; ok {
+				l.remove(element)
+				removed++
+			}
+		}
+
+		delete(l.tags, tag)
+	}
+
+	return removed, nil
+}
+
+func (l *lruCache) Tags(prefix string) ([]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var tags []string
+	for tag := range l.tags {
+		if strings.HasPrefix(tag, prefix) {
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags, nil
+}
+
+// remove drops an entry and its tag memberships. Callers must hold l.mu.
+func (l *lruCache) remove(element *list.Element) {
+	entry := l.order.Remove(element).(*lruEntry)
+	delete(l.entries, entry.key)
+
+	for _, tag := range entry.tags {
+		delete(l.tags[tag], entry.key)
+		if len(l.tags[tag]) == 0 {
+			delete(l.tags, tag)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package util
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestLRUGetSet(t *testing.T) {
+	cache := newLRUCache(2)
+
+	if _, err := cache.Get("a"); err != errCacheMiss {
+		t.Errorf("empty cache: got %v, want a miss", err)
+	}
+
+	cache.Set("a", []byte("1"), nil, time.Minute)
+	cache.Set("a", []byte("2"), nil, time.Minute)
+
+	value, err := cache.Get("a")
+	if err != nil || string(value) != "2" {
+		t.Errorf("got %q, %v, want the latest value", value, err)
+	}
+}
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newLRUCache(2)
+
+	cache.Set("a", []byte("1"), nil, time.Minute)
+	cache.Set("b", []byte("2"), nil, time.Minute)
+
+	// Reading a makes b the least recently used.
+	if _, err := cache.Get("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("c", []byte("3"), nil, time.Minute)
+
+	if _, err := cache.Get("b"); err != errCacheMiss {
+		t.Errorf("b: got %v, want it evicted", err)
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, err := cache.Get(key); err != nil {
+			t.Errorf("%s: %v", key, err)
+		}
+	}
+}
+
+func TestLRUExpires(t *testing.T) {
+	cache := newLRUCache(2)
+
+	cache.Set("a", []byte("1"), []string{"mode"}, -time.Second)
+
+	if _, err := cache.Get("a"); err != errCacheMiss {
+		t.Errorf("got %v, want an expired entry to miss", err)
+	}
+
+	if tags, _ := cache.Tags(""); len(tags) != 0 {
+		t.Errorf("got tags %v after the only entry expired", tags)
+	}
+}
+
+func TestLRUInvalidate(t *testing.T) {
+	cache := newLRUCache(10)
+
+	cache.Set("board", []byte("1"), []string{"mode.arena.solo", "games"}, time.Minute)
+	cache.Set("list", []byte("2"), []string{"games"}, time.Minute)
+	cache.Set("other", []byte("3"), []string{"mode.arena.duo"}, time.Minute)
+
+	tags, _ := cache.Tags("mode.")
+	sort.Strings(tags)
+	if want := []string{"mode.arena.duo", "mode.arena.solo"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("got tags %v, want %v", tags, want)
+	}
+
+	removed, err := cache.Invalidate([]string{"games"})
+	if err != nil || removed != 2 {
+		t.Errorf("got %d, %v, want 2 entries removed", removed, err)
+	}
+
+	for _, key := range []string{"board", "list"} {
+		if _, err := cache.Get(key); err != errCacheMiss {
+			t.Errorf("%s: got %v, want it invalidated", key, err)
+		}
+	}
+	if _, err := cache.Get("other"); err != nil {
+		t.Errorf("other: %v", err)
+	}
+
+	// board's other tag goes with it.
+	tags, _ = cache.Tags("mode.")
+	if want := []string{"mode.arena.duo"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("got tags %v, want %v", tags, want)
+	}
+}
@@ -1,60 +1,29 @@
 package util
 
-import (
-	"github.com/gin-gonic/gin"
-	"github.com/simagix/keyhole/mdb"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
-	"log"
-)
-
 type MongoResult struct {
-	TimeCode          int64             `bson:"time_code"`
-	ServerEventType   string            `bson:"server_event_type"`
-	GameID            string            `bson:"game_id"`
-	GameModeID        string            `bson:"game_mode_id"`
-	AnalyticEventType string            `bson:"analytic_event_type"`
-	WorldName         string            `bson:"world_name"`
-	From              string            `bson:"from"`
-	To                string            `bson:"to"`
-	Value             int32             `bson:"value"`
-	ScoreField        string            `bson:"score_field"`
-	PlayerName        string            `bson:"player_name"`
-	PlayerUUID        string            `bson:"player_uuid"`
-	DeathEventType    string            `bson:"death_event_type"`
-	DeathDetails      string            `bson:"death_details"`
-	KillerName        string            `bson:"killer_name"`
-	KillerUUID        string            `bson:"killer_uuid"`
-	Winners           map[string]string `bson:"winners"`
-	Losers            map[string]string `bson:"losers"`
-	FinishEventType   string            `bson:"finish_event_type"`
-	Author            string            `bson:"author"`
-	Phrase            string            `bson:"phrase"`
-	Tags              map[string]string `bson:"tags"`
-	TimeSinceStart    int64             `bson:"time_since_start"`
-}
-
-func RunPipelineOnEvents(pipeline string, client *mongo.Client, ctx *gin.Context, action func(result MongoResult)) {
-	var err error
-	var collection *mongo.Collection
-	var cur *mongo.Cursor
-
-	collection = client.Database("Analytics").Collection("Events")
-	opts := options.Aggregate()
-	opts.SetAllowDiskUse(true)
-
-	if cur, err = collection.Aggregate(ctx, mdb.MongoPipeline(pipeline), opts); err != nil {
-		log.Fatal(err)
-	}
-	defer cur.Close(ctx)
-
-	for cur.Next(ctx) {
-		var result MongoResult
-		err := cur.Decode(&result)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		action(result)
-	}
+	EventID           string            `bson:"event_id,omitempty" json:"event_id"`
+	TimeCode          int64             `bson:"time_code" json:"time_code"`
+	ServerEventType   string            `bson:"server_event_type,omitempty" json:"server_event_type"`
+	GameID            string            `bson:"game_id,omitempty" json:"game_id"`
+	GameModeID        string            `bson:"game_mode_id,omitempty" json:"game_mode_id"`
+	InstanceID        string            `bson:"instance_id,omitempty" json:"instance_id"`
+	AnalyticEventType string            `bson:"analytic_event_type,omitempty" json:"analytic_event_type"`
+	WorldName         string            `bson:"world_name,omitempty" json:"world_name"`
+	From              string            `bson:"from,omitempty" json:"from"`
+	To                string            `bson:"to,omitempty" json:"to"`
+	Value             int32             `bson:"value" json:"value"`
+	ScoreField        string            `bson:"score_field,omitempty" json:"score_field"`
+	PlayerName        string            `bson:"player_name,omitempty" json:"player_name"`
+	PlayerUUID        string            `bson:"player_uuid,omitempty" json:"player_uuid"`
+	DeathEventType    string            `bson:"death_event_type,omitempty" json:"death_event_type"`
+	DeathDetails      string            `bson:"death_details,omitempty" json:"death_details"`
+	KillerName        string            `bson:"killer_name,omitempty" json:"killer_name"`
+	KillerUUID        string            `bson:"killer_uuid,omitempty" json:"killer_uuid"`
+	Winners           map[string]string `bson:"winners,omitempty" json:"winners"`
+	Losers            map[string]string `bson:"losers,omitempty" json:"losers"`
+	FinishEventType   string            `bson:"finish_event_type,omitempty" json:"finish_event_type"`
+	Author            string            `bson:"author,omitempty" json:"author"`
+	Phrase            string            `bson:"phrase,omitempty" json:"phrase"`
+	Tags              map[string]string `bson:"tags,omitempty" json:"tags"`
+	TimeSinceStart    int64             `bson:"time_since_start" json:"time_since_start"`
 }
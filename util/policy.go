@@ -0,0 +1,45 @@
+package util
+
+import (
+	"fmt"
+	"time"
+)
+
+// CachePolicy decides how long a route's responses are served from the cache.
+type CachePolicy struct {
+	// TTL is how long a response counts as fresh.
+	TTL time.Duration
+	// Stale is how much longer an expired response is kept as a fallback.
+	Stale time.Duration
+	// Refresh serves stale responses straight away and recomputes them in
+	// the background. Without it the first request after TTL waits for the
+	// recomputation and the stale response is only used if that fails.
+	Refresh bool
+}
+
+var DefaultPolicy = CachePolicy{TTL: 5 * time.Minute}
+
+// lifetime is how long an entry is kept in the cache at all.
+func (p CachePolicy) lifetime() time.Duration {
+	return p.TTL + p.Stale
+}
+
+func (p CachePolicy) fresh(entry cacheEntry) bool {
+	return time.Since(entry.Created) < p.TTL
+}
+
+// cacheControl tells clients how much longer the entry stays fresh, and
+// whether they may keep using it while it is refreshed in the background.
+func (p CachePolicy) cacheControl(entry cacheEntry) string {
+	maxAge := (p.TTL - time.Since(entry.Created)) / time.Second
+	if maxAge < 0 {
+		maxAge = 0
+	}
+
+	header := fmt.Sprintf("public, max-age=%d", maxAge)
+	if p.Refresh && p.Stale > 0 {
+		header += fmt.Sprintf(", stale-while-revalidate=%d", p.Stale/time.Second)
+	}
+
+	return header
+}
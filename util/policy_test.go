@@ -0,0 +1,111 @@
+package util
+
+import (
+	"github.com/gin-gonic/gin"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCachePolicyControl(t *testing.T) {
+	tests := []struct {
+		policy CachePolicy
+		age    time.Duration
+		want   string
+	}{
+		{CachePolicy{TTL: time.Minute}, 0, "public, max-age=60"},
+		{CachePolicy{TTL: time.Minute}, 2 * time.Minute, "public, max-age=0"},
+		{CachePolicy{TTL: time.Minute, Stale: 5 * time.Minute}, 0, "public, max-age=60"},
+		{CachePolicy{TTL: time.Minute, Stale: 5 * time.Minute, Refresh: true}, 30 * time.Second, "public, max-age=30, stale-while-revalidate=300"},
+	}
+
+	for _, test := range tests {
+		// A little slack so the elapsed time rounds down to whole seconds.
+		entry := cacheEntry{Created: time.Now().Add(-test.age + 500*time.Millisecond)}
+		if got := test.policy.cacheControl(entry); got != test.want {
+			t.Errorf("%+v aged %v: got %q, want %q", test.policy, test.age, got, test.want)
+		}
+	}
+
+	policy := CachePolicy{TTL: time.Minute, Stale: 5 * time.Minute}
+	if policy.lifetime() != 6*time.Minute {
+		t.Errorf("got lifetime %v, want TTL plus Stale", policy.lifetime())
+	}
+}
+
+// age moves the entry cached for a response back in time.
+func age(t *testing.T, key string, by time.Duration) {
+	entry, err := cachedEntry(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry.Created = entry.Created.Add(-by)
+	if err := cache.Set(key, entry.marshal(), nil, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	useMemoryCache()
+
+	var calls int32
+	r := gin.New()
+	CachedGETWithPolicy(r, "/list", CachePolicy{TTL: time.Minute, Stale: 5 * time.Minute, Refresh: true}, func(c *gin.Context) []byte {
+		return []byte(strconv.Itoa(int(atomic.AddInt32(&calls, 1))))
+	})
+
+	key := get(r, "/list").Header().Get("X-Cache-Key")
+	age(t, key, 2*time.Minute)
+
+	stale := get(r, "/list")
+	if stale.Header().Get("X-Cache") != "STALE" || stale.Body.String() != "1" {
+		t.Errorf("got %s %q, want the stale response straight away", stale.Header().Get("X-Cache"), stale.Body.String())
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if entry, err := cachedEntry(key); err == nil && string(entry.Body) == "2" {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("the stale entry was never refreshed in the background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if fresh := get(r, "/list"); fresh.Header().Get("X-Cache") != "HIT" || fresh.Body.String() != "2" {
+		t.Errorf("got %s %q, want the refreshed response", fresh.Header().Get("X-Cache"), fresh.Body.String())
+	}
+}
+
+func TestStaleFallback(t *testing.T) {
+	useMemoryCache()
+
+	failing := false
+	r := gin.New()
+	CachedGETWithPolicy(r, "/list", CachePolicy{TTL: time.Minute, Stale: 5 * time.Minute}, func(c *gin.Context) []byte {
+		if failing {
+			c.JSON(500, gin.H{"error": "down"})
+			return nil
+		}
+
+		return []byte("fresh")
+	})
+
+	key := get(r, "/list").Header().Get("X-Cache-Key")
+	age(t, key, 2*time.Minute)
+
+	// Without Refresh an expired entry is only served when recomputing fails.
+	failing = true
+	if response := get(r, "/list"); response.Header().Get("X-Cache") != "STALE" || response.Code != 200 || response.Body.String() != "fresh" {
+		t.Errorf("got %s %d %q, want the stale response", response.Header().Get("X-Cache"), response.Code, response.Body.String())
+	}
+
+	failing = false
+	if response := get(r, "/list"); response.Header().Get("X-Cache") != "MISS" || response.Body.String() != "fresh" {
+		t.Errorf("got %s %q, want a recomputed response", response.Header().Get("X-Cache"), response.Body.String())
+	}
+}
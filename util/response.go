@@ -0,0 +1,138 @@
+package util
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const jsonContentType = "application/json; charset=utf-8"
+
+// cacheEntry is a complete response as stored in the cache.
+type cacheEntry struct {
+	Status      int       `json:"status"`
+	ContentType string    `json:"content_type"`
+	Body        []byte    `json:"body"`
+	Created     time.Time `json:"created"`
+	ETag        string    `json:"etag"`
+}
+
+// cacheable reports whether the entry is a successful response worth
+// storing. Errors are never cached so a transient failure isn't replayed.
+func (e cacheEntry) cacheable() bool {
+	return e.Status == http.StatusOK && len(e.Body) > 0
+}
+
+func (e cacheEntry) marshal() []byte {
+	data, _ := json.Marshal(e)
+
+	return data
+}
+
+// serve writes the entry along with the validators and freshness lifetime
+// clients need to revalidate it, answering conditional requests with a 304
+// when the client's copy is still current.
+func (e cacheEntry) serve(c *gin.Context, policy CachePolicy) {
+	if !e.cacheable() {
+		c.Header("Cache-Control", "no-store")
+		c.Data(e.Status, e.ContentType, e.Body)
+
+		return
+	}
+
+	if e.ETag == "" {
+		e.ETag = etag(e.Body)
+	}
+
+	c.Header("ETag", e.ETag)
+	c.Header("Last-Modified", e.Created.UTC().Format(http.TimeFormat))
+	c.Header("Cache-Control", policy.cacheControl(e))
+
+	if e.notModified(c.Request) {
+		c.Status(http.StatusNotModified)
+
+		return
+	}
+
+	c.Data(e.Status, e.ContentType, e.Body)
+}
+
+// notModified evaluates If-None-Match, falling back to If-Modified-Since only
+// when the client sent no entity tags, as RFC 7232 requires.
+func (e cacheEntry) notModified(r *http.Request) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == e.ETag {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+
+	return !e.Created.Truncate(time.Second).After(since)
+}
+
+// etag is a strong validator over the response body.
+func etag(body []byte) string {
+	sum := sha1.Sum(body)
+
+	return fmt.Sprintf("%q", hex.EncodeToString(sum[:]))
+}
+
+// captureWriter buffers whatever a handler writes itself, such as c.JSON
+// error responses, instead of sending it to the client straight away.
+type captureWriter struct {
+	gin.ResponseWriter
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (w *captureWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *captureWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *captureWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+// capture runs a CachedGET handler and records its status, content type and
+// body, whether it returned a payload or wrote an error response itself. The
+// real writer is put back even if the handler panics, so whoever recovers can
+// still answer the client.
+func capture(c *gin.Context, action func(*gin.Context) []byte) cacheEntry {
+	writer := &captureWriter{ResponseWriter: c.Writer, header: make(http.Header)}
+	c.Writer = writer
+	defer func() {
+		c.Writer = writer.ResponseWriter
+	}()
+
+	response := action(c)
+
+	if writer.body.Len() > 0 {
+		contentType := writer.header.Get("Content-Type")
+		if contentType == "" {
+			contentType = jsonContentType
+		}
+
+		return cacheEntry{Status: writer.Status(), ContentType: contentType, Body: writer.body.Bytes(), Created: time.Now(), ETag: etag(writer.body.Bytes())}
+	}
+
+	return cacheEntry{Status: writer.Status(), ContentType: jsonContentType, Body: response, Created: time.Now(), ETag: etag(response)}
+}
@@ -0,0 +1,155 @@
+package util
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCachedGETCachesSuccess(t *testing.T) {
+	useMemoryCache()
+
+	calls := 0
+	r := gin.New()
+	CachedGET(r, "/ok", func(c *gin.Context) []byte {
+		calls++
+		return []byte(`{"ok":true}`)
+	})
+
+	for i := 0; i < 2; i++ {
+		response := get(r, "/ok")
+
+		if response.Code != http.StatusOK || response.Body.String() != `{"ok":true}` {
+			t.Errorf("got %d %q", response.Code, response.Body.String())
+		}
+		if got := response.Header().Get("Content-Type"); got != jsonContentType {
+			t.Errorf("got content type %q, want %q", got, jsonContentType)
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("handler ran %d times, want the second response cached", calls)
+	}
+}
+
+func TestCachedGETSkipsErrors(t *testing.T) {
+	useMemoryCache()
+
+	calls := 0
+	r := gin.New()
+	CachedGET(r, "/missing", func(c *gin.Context) []byte {
+		calls++
+		c.JSON(404, gin.H{"error": "not found"})
+		return nil
+	})
+	CachedGET(r, "/text", func(c *gin.Context) []byte {
+		calls++
+		c.Data(503, "text/plain", []byte("down"))
+		return nil
+	})
+	CachedGET(r, "/empty", func(c *gin.Context) []byte {
+		calls++
+		return nil
+	})
+
+	tests := []struct {
+		path        string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"/missing", 404, jsonContentType, `{"error":"not found"}`},
+		{"/text", 503, "text/plain", "down"},
+		{"/empty", 200, jsonContentType, ""},
+	}
+
+	for _, test := range tests {
+		calls = 0
+		for i := 0; i < 2; i++ {
+			response := get(r, test.path)
+
+			if response.Code != test.status || response.Body.String() != test.body {
+				t.Errorf("%s: got %d %q, want %d %q", test.path, response.Code, response.Body.String(), test.status, test.body)
+			}
+			if got := response.Header().Get("Content-Type"); got != test.contentType {
+				t.Errorf("%s: got content type %q, want %q", test.path, got, test.contentType)
+			}
+			if got := response.Header().Get("Cache-Control"); got != "no-store" {
+				t.Errorf("%s: got Cache-Control %q, want no-store", test.path, got)
+			}
+		}
+
+		if calls != 2 {
+			t.Errorf("%s: handler ran %d times, want errors never cached", test.path, calls)
+		}
+	}
+}
+
+func TestCachedGETValidators(t *testing.T) {
+	useMemoryCache()
+
+	r := gin.New()
+	CachedGET(r, "/games", func(c *gin.Context) []byte {
+		return []byte(`["arena"]`)
+	})
+
+	first := get(r, "/games")
+	tag := first.Header().Get("ETag")
+	modified := first.Header().Get("Last-Modified")
+
+	if tag != etag([]byte(`["arena"]`)) || !strings.HasPrefix(tag, `"`) {
+		t.Errorf("got ETag %q, want a quoted hash of the body", tag)
+	}
+	if _, err := http.ParseTime(modified); err != nil {
+		t.Errorf("got Last-Modified %q: %v", modified, err)
+	}
+	if got := first.Header().Get("Cache-Control"); !strings.HasPrefix(got, "public, max-age=") {
+		t.Errorf("got Cache-Control %q, want a public max-age", got)
+	}
+
+	tests := []struct {
+		name    string
+		headers []string
+		status  int
+	}{
+		{"matching tag", []string{"If-None-Match", tag}, http.StatusNotModified},
+		{"weak tag in a list", []string{"If-None-Match", `"other", W/` + tag}, http.StatusNotModified},
+		{"wildcard", []string{"If-None-Match", "*"}, http.StatusNotModified},
+		{"other tag", []string{"If-None-Match", `"other"`}, http.StatusOK},
+		{"other tag with a later date", []string{"If-None-Match", `"other"`, "If-Modified-Since", modified}, http.StatusOK},
+		{"unchanged since", []string{"If-Modified-Since", modified}, http.StatusNotModified},
+		{"changed since", []string{"If-Modified-Since", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)}, http.StatusOK},
+		{"bad date", []string{"If-Modified-Since", "yesterday"}, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		response := get(r, "/games", test.headers...)
+
+		if response.Code != test.status {
+			t.Errorf("%s: got %d, want %d", test.name, response.Code, test.status)
+		}
+		if test.status == http.StatusNotModified && response.Body.Len() > 0 {
+			t.Errorf("%s: got a body %q with a 304", test.name, response.Body.String())
+		}
+		if response.Header().Get("ETag") != tag {
+			t.Errorf("%s: got ETag %q, want %q", test.name, response.Header().Get("ETag"), tag)
+		}
+	}
+}
+
+func TestCachedGETErrorsHaveNoValidators(t *testing.T) {
+	useMemoryCache()
+
+	r := gin.New()
+	CachedGET(r, "/broken", func(c *gin.Context) []byte {
+		c.JSON(500, gin.H{"error": "down"})
+		return nil
+	})
+
+	response := get(r, "/broken", "If-None-Match", "*")
+	if response.Code != 500 || response.Header().Get("ETag") != "" {
+		t.Errorf("got %d with ETag %q, want a plain 500", response.Code, response.Header().Get("ETag"))
+	}
+}
@@ -0,0 +1,170 @@
+package util
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// AllGamesTag marks responses computed over every game, which any new result
+// can change.
+const AllGamesTag = "games"
+
+const tagsKey = "cache.tags"
+
+func GameTag(game string) string {
+	if game == "" {
+		return AllGamesTag
+	}
+
+	return "game." + game
+}
+
+func ModeTag(game, mode string) string {
+	if mode == "" {
+		return GameTag(game)
+	}
+
+	return modeTagPrefix(game) + mode
+}
+
+func modeTagPrefix(game string) string {
+	return "mode." + game + "."
+}
+
+func PlayerTag(uuid string) string {
+	return "player." + uuid
+}
+
+func InstanceTag(id string) string {
+	return "instance." + id
+}
+
+func SeasonTag(season string) string {
+	return "season." + season
+}
+
+// Tag records which tags the response being built by a CachedGET handler
+// depends on, so that invalidating any of them evicts it.
+func Tag(c *gin.Context, tags ...string) {
+	existing := c.GetStringSlice(tagsKey)
+	c.Set(tagsKey, append(existing, tags...))
+}
+
+// InvalidateTags evicts every cached response recorded under the given tags
+// and returns how many cache entries were removed.
+func InvalidateTags(tags ...string) (int, error) {
+	return cache.Invalidate(tags)
+}
+
+// EventTags lists the tags of every response that an event can change.
+// Only events that feed leaderboards, profiles or game lists are considered.
+func EventTags(event MongoResult) []string {
+	switch event.AnalyticEventType {
+	case "Score", "Death", "Finish":
+	default:
+		return nil
+	}
+
+	tags := []string{
+		AllGamesTag,
+		GameTag(event.GameID),
+		ModeTag(event.GameID, event.GameModeID),
+		InstanceTag(event.InstanceID),
+	}
+
+	for _, player := range []string{event.PlayerUUID, event.KillerUUID} {
+		if player != "" {
+			tags = append(tags, PlayerTag(player))
+		}
+	}
+
+	for player := range event.Winners {
+		tags = append(tags, PlayerTag(player))
+	}
+
+	for player := range event.Losers {
+		tags = append(tags, PlayerTag(player))
+	}
+
+	return tags
+}
+
+// InvalidateEvents evicts every cached response affected by newly stored
+// events.
+func InvalidateEvents(events []MongoResult) error {
+	seen := make(map[string]bool)
+
+	var tags []string
+	for _, event := range events {
+		for _, tag := range EventTags(event) {
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	if len(tags) == 0 {
+		return nil
+	}
+
+	_, err := InvalidateTags(tags...)
+
+	return err
+}
+
+type invalidateRequest struct {
+	Tags     []string `json:"tags"`
+	Game     string   `json:"game"`
+	Mode     string   `json:"mode"`
+	Player   string   `json:"player"`
+	Instance string   `json:"instance"`
+}
+
+// RegisterCacheRoutes exposes manual invalidation for admins, guarded by
+// ADMIN_TOKEN.
+func RegisterCacheRoutes(r *gin.Engine) {
+	r.POST("/v1/cache/invalidate", RequireToken("ADMIN_TOKEN"), invalidateHandler)
+}
+
+func invalidateHandler(c *gin.Context) {
+	var request invalidateRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	tags := request.Tags
+	if request.Game != "" {
+		if request.Mode != "" {
+			tags = append(tags, ModeTag(request.Game, request.Mode))
+		} else {
+			modes, err := cache.Tags(modeTagPrefix(request.Game))
+			if err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+
+			tags = append(tags, GameTag(request.Game))
+			tags = append(tags, modes...)
+		}
+	}
+	if request.Player != "" {
+		tags = append(tags, PlayerTag(request.Player))
+	}
+	if request.Instance != "" {
+		tags = append(tags, InstanceTag(request.Instance))
+	}
+
+	if len(tags) == 0 {
+		c.JSON(400, gin.H{"error": "No tags provided"})
+		return
+	}
+
+	removed, err := InvalidateTags(tags...)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"tags": tags, "removed": removed})
+}
@@ -0,0 +1,111 @@
+package util
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// tieredCache serves from Redis while it is reachable and from the in-process
+// LRU while it isn't. Tags invalidated during an outage are replayed against
+// Redis once it recovers, so it never serves a response we meant to evict.
+type tieredCache struct {
+	redis  *redisCache
+	memory *lruCache
+
+	mu      sync.Mutex
+	online  bool
+	pending map[string]bool
+}
+
+func (t *tieredCache) backend() cacheBackend {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.online {
+		return t.redis
+	}
+
+	return t.memory
+}
+
+func (t *tieredCache) Get(key string) ([]byte, error) {
+	return t.backend().Get(key)
+}
+
+func (t *tieredCache) Set(key string, value []byte, tags []string, ttl time.Duration) error {
+	return t.backend().Set(key, value, tags, ttl)
+}
+
+func (t *tieredCache) Invalidate(tags []string) (int, error) {
+	t.mu.Lock()
+	online, memory := t.online, t.memory
+	if !online {
+		for _, tag := range tags {
+			t.pending[tag] = true
+		}
+	}
+	t.mu.Unlock()
+
+	if online {
+		return t.redis.Invalidate(tags)
+	}
+
+	return memory.Invalidate(tags)
+}
+
+func (t *tieredCache) Tags(prefix string) ([]string, error) {
+	return t.backend().Tags(prefix)
+}
+
+// monitor pings Redis forever, demoting to the LRU when it stops answering
+// and promoting it back once it does.
+func (t *tieredCache) monitor(interval time.Duration) {
+	for range time.Tick(interval) {
+		err := t.redis.client.Ping().Err()
+
+		t.mu.Lock()
+		online := t.online
+		t.mu.Unlock()
+
+		if err != nil && online {
+			log.Println("Lost connection to redis... caching in memory. ", err)
+			t.demote()
+		} else if err == nil && !online {
+			log.Println("Reconnected to redis... caching in redis again.")
+			t.promote()
+		}
+	}
+}
+
+// demote switches to a fresh LRU; anything left in it from an earlier outage
+// missed the invalidations Redis received since.
+func (t *tieredCache) demote() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.memory = newLRUCache(t.memory.size)
+	t.online = false
+}
+
+func (t *tieredCache) promote() {
+	t.mu.Lock()
+	tags := make([]string, 0, len(t.pending))
+	for tag := range t.pending {
+		tags = append(tags, tag)
+	}
+	t.mu.Unlock()
+
+	if _, err := t.redis.Invalidate(tags); err != nil {
+		log.Println("Failed to replay cache invalidations, staying in memory. ", err)
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, tag := range tags {
+		delete(t.pending, tag)
+	}
+	t.online = true
+}
@@ -0,0 +1,50 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTieredCacheServesFromMemoryWhileOffline(t *testing.T) {
+	useMemoryCache()
+
+	if err := cache.Set("list", []byte("1"), []string{"games"}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := cache.memory.Get("list")
+	if err != nil || string(value) != "1" {
+		t.Errorf("got %q, %v from the LRU", value, err)
+	}
+}
+
+func TestTieredCacheReplaysInvalidations(t *testing.T) {
+	remote, done := testRedis(t)
+	defer done()
+
+	tiered := &tieredCache{redis: remote, memory: newLRUCache(10), pending: make(map[string]bool)}
+
+	if err := remote.Set("list", []byte("1"), []string{"games"}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	// Invalidated while Redis looked down, so only the LRU saw it.
+	if _, err := tiered.Invalidate([]string{"games"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := remote.Get("list"); err != nil {
+		t.Fatalf("Redis lost the entry before recovering: %v", err)
+	}
+
+	tiered.promote()
+
+	if !tiered.online {
+		t.Error("still offline after promoting")
+	}
+	if _, err := remote.Get("list"); err != errCacheMiss {
+		t.Errorf("got %v, want the invalidation replayed against Redis", err)
+	}
+	if len(tiered.pending) != 0 {
+		t.Errorf("got pending tags %v after replaying them", tiered.pending)
+	}
+}
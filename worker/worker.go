@@ -0,0 +1,67 @@
+package worker
+
+import (
+	"LeaderboardsBackend/store"
+	"LeaderboardsBackend/util"
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	// retryDelay is how long the worker waits before reopening a failed
+	// stream.
+	retryDelay = 5 * time.Second
+	// batchSize and batchLinger bound how many events the hooks receive at
+	// once and how long an event waits for others to join its batch.
+	batchSize   = 500
+	batchLinger = time.Second
+)
+
+// Worker keeps derived data fresh for events written by any producer, not just
+// the ingestion endpoint, by tailing the Events collection. Inserted events
+// are passed to the hooks in batches, and take the place of ingestion hooks
+// when the worker runs. A batch is handed over again when a hook fails or the
+// process dies before the stream position is saved, so hooks must be
+// idempotent.
+type Worker struct {
+	Events *store.MongoStore
+	// Hooks receive each batch of inserted events, e.g. to update rollups
+	// or invalidate cached responses. Hooks after a failing one are skipped
+	// until the batch is retried.
+	Hooks []func(events []util.MongoResult) error
+	// Rebuilds recompute derived data from scratch. They run when the stream
+	// can't resume and events may have been missed.
+	Rebuilds []func()
+}
+
+// Run tails events forever, reopening the stream after failures.
+func (w *Worker) Run() {
+	for {
+		err := w.Events.TailEvents(context.Background(), batchSize, batchLinger, w.handle)
+		if err == store.ErrHistoryLost {
+			log.Println("Event stream can't resume... rebuilding derived data.")
+			w.rebuild()
+			continue
+		}
+
+		log.Println("Event stream failed... retrying. ", err)
+		time.Sleep(retryDelay)
+	}
+}
+
+func (w *Worker) handle(events []util.MongoResult) error {
+	for _, hook := range w.Hooks {
+		if err := hook(events); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *Worker) rebuild() {
+	for _, rebuild := range w.Rebuilds {
+		rebuild()
+	}
+}